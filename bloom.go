@@ -0,0 +1,75 @@
+package gharchive
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// bloomFilter is a bloom filter that uses double hashing of a 64-bit FNV-1a hash.
+type bloomFilter struct {
+	bits []uint64
+	k    uint64
+}
+
+// bloomMaxHashes is the most hash functions a bloomFilter uses. Useful false positive rates need far fewer.
+const bloomMaxHashes = 32
+
+// newBloomFilter returns a bloomFilter sized for n items with a false positive rate of p.
+func newBloomFilter(n int, p float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	m := math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	k := math.Round(m / float64(n) * math.Ln2)
+	if k < 1 {
+		k = 1
+	}
+	if k > bloomMaxHashes {
+		k = bloomMaxHashes
+	}
+	return &bloomFilter{
+		bits: make([]uint64, (uint64(m)+63)/64),
+		k:    uint64(k),
+	}
+}
+
+func (b *bloomFilter) hashes(data []byte) (h1, h2 uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write(data) //nolint:errcheck // hash.Hash never returns an error
+	sum := mix64(h.Sum64())
+	h1, h2 = sum&0xffffffff, sum>>32
+	return h1, h2 | 1
+}
+
+// mix64 is the murmur3 finalizer. FNV alone leaves the high bits poorly mixed for short inputs that differ
+// near the end.
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+func (b *bloomFilter) add(data []byte) {
+	m := uint64(len(b.bits)) * 64
+	h1, h2 := b.hashes(data)
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h1 + i*h2) % m
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// has returns true when data may have been added. It returns false when data definitely hasn't.
+func (b *bloomFilter) has(data []byte) bool {
+	m := uint64(len(b.bits)) * 64
+	h1, h2 := b.hashes(data)
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h1 + i*h2) % m
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
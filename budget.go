@@ -0,0 +1,57 @@
+package gharchive
+
+import (
+	"context"
+	"sync"
+)
+
+// byteBudget limits the number of bytes buffered between workers and the consumer.
+type byteBudget struct {
+	max   int64 // no limit when <= 0
+	mux   sync.Mutex
+	used  int64
+	freed chan struct{} // closed and replaced whenever bytes are released
+}
+
+func newByteBudget(max int64) *byteBudget {
+	return &byteBudget{
+		max:   max,
+		freed: make(chan struct{}),
+	}
+}
+
+// acquire waits until n bytes fit in the budget. A request is always granted when nothing is buffered so that a
+// batch bigger than the whole budget can't block forever.
+func (b *byteBudget) acquire(ctx context.Context, n int64) error {
+	for {
+		b.mux.Lock()
+		if b.max <= 0 || b.used == 0 || b.used+n <= b.max {
+			b.used += n
+			b.mux.Unlock()
+			return nil
+		}
+		freed := b.freed
+		b.mux.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-freed:
+		}
+	}
+}
+
+// release returns n bytes to the budget.
+func (b *byteBudget) release(n int64) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.used -= n
+	close(b.freed)
+	b.freed = make(chan struct{})
+}
+
+// usage returns the number of bytes currently acquired.
+func (b *byteBudget) usage() int64 {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.used
+}
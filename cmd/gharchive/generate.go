@@ -0,0 +1,39 @@
+package main
+
+import (
+	"time"
+
+	"github.com/willabides/gharchive-client/gharchivegen"
+)
+
+type generateCmd struct {
+	timeRangeArgs
+	Dest          string         `kong:"required,type=path,help='directory to write hour files to'"`
+	EventsPerHour int            `kong:"default=10000,help='number of events in each hour'"`
+	TypeWeights   map[string]int `kong:"name=type-weight,help='relative weight of an event type formatted as Type=weight. Can be repeated. default is a mix resembling recent gharchive data'"`
+	Repos         int            `kong:"default=10000,help='number of distinct repos'"`
+	Actors        int            `kong:"default=10000,help='number of distinct actors'"`
+	Orgs          int            `kong:"help='number of distinct orgs'"`
+	PayloadSize   int            `kong:"default=200,help='approximate size in bytes of each event payload'"`
+	Seed          int64          `kong:"help='seed for the random source'"`
+}
+
+func (c *generateCmd) Run() error {
+	start, end, err := c.timeRange(time.Hour)
+	if err != nil {
+		return err
+	}
+	gen, err := gharchivegen.New(&gharchivegen.Options{
+		EventsPerHour: c.EventsPerHour,
+		TypeWeights:   c.TypeWeights,
+		Repos:         c.Repos,
+		Actors:        c.Actors,
+		Orgs:          c.Orgs,
+		PayloadSize:   c.PayloadSize,
+		Seed:          c.Seed,
+	})
+	if err != nil {
+		return err
+	}
+	return gen.WriteDir(c.Dest, start, end)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/willabides/gharchive-client"
+)
+
+type indexCmd struct {
+	timeRangeArgs
+	Dir         string `kong:"type=existingdir,help='read hour files from this directory, like one written by gharchive mirror, instead of data.gharchive.org'"`
+	Dest        string `kong:"type=path,help='directory to write index files to. Default is --dir.'"`
+	Seek        bool   `kong:"help='also write seek index files that let scans with --strict-created-at start reading close to start'"`
+	Rebuild     bool   `kong:"help='rebuild index files that already exist'"`
+	Concurrency int    `kong:"help='number of hours to index at once. Default is the number of cpus available.'"`
+}
+
+func (c *indexCmd) Run() error {
+	ctx := context.Background()
+	start, end, err := c.timeRange(24 * time.Hour)
+	if err != nil {
+		return err
+	}
+	if c.Dest == "" {
+		c.Dest = c.Dir
+	}
+	if c.Dest == "" {
+		return errors.New("--dest is required without --dir")
+	}
+	if c.Concurrency == 0 {
+		c.Concurrency = runtime.NumCPU()
+	}
+	opts := new(gharchive.Options)
+	if c.Dir != "" {
+		opts.Source = gharchive.NewDirSource(c.Dir)
+	}
+	src, err := gharchive.NewSource(ctx, opts)
+	if err != nil {
+		return err
+	}
+	result, err := indexHours(ctx, src, &indexOptions{
+		layout:      opts.Layout(),
+		start:       start,
+		end:         end,
+		dest:        c.Dest,
+		seek:        c.Seek,
+		rebuild:     c.Rebuild,
+		concurrency: c.Concurrency,
+	})
+	if result == nil {
+		return err
+	}
+	fmt.Printf("indexed %d, skipped %d, missing %d\n", len(result.indexed), len(result.skipped), len(result.missing))
+	return err
+}
+
+type indexOptions struct {
+	layout      gharchive.ObjectLayout
+	start, end  time.Time
+	dest        string
+	seek        bool // also write seek index files
+	rebuild     bool
+	concurrency int
+}
+
+// indexResult lists the names of the objects that indexHours handled in hour order.
+type indexResult struct {
+	indexed []string
+	skipped []string // already have index files in dest
+	missing []string // not in the source
+}
+
+// indexHours writes an index file, and a seek index file when opts.seek is set, to opts.dest for the object in src for
+// each period of opts.layout from the one containing start up to end.
+func indexHours(ctx context.Context, src gharchive.Source, opts *indexOptions) (*indexResult, error) {
+	err := os.MkdirAll(opts.dest, 0o750)
+	if err != nil {
+		return nil, err
+	}
+	var hours []time.Time
+	period := opts.layout.Period()
+	for hour := opts.start.UTC().Truncate(period); hour.Before(opts.end); hour = hour.Add(period) {
+		hours = append(hours, hour)
+	}
+	const (
+		indexed = iota
+		skipped
+		missing
+	)
+	outcomes := make([]int, len(hours))
+	errs := make([]error, len(hours))
+	runConcurrently(len(hours), opts.concurrency, func(i int) {
+		name := opts.layout.ObjectName(hours[i])
+		path := filepath.Join(opts.dest, gharchive.IndexName(name))
+		seekPath := filepath.Join(opts.dest, gharchive.SeekIndexName(name))
+		if !opts.rebuild && fileExists(path) && (!opts.seek || fileExists(seekPath)) {
+			outcomes[i] = skipped
+			return
+		}
+		err := writeIndex(ctx, src, opts.layout, hours[i], path)
+		if err == nil && opts.seek {
+			err = writeSeekIndex(ctx, src, name, seekPath)
+		}
+		if errors.Is(err, gharchive.ErrObjectNotExist) {
+			outcomes[i] = missing
+			return
+		}
+		errs[i] = err
+	})
+	result := new(indexResult)
+	for i, hour := range hours {
+		name := opts.layout.ObjectName(hour)
+		if errs[i] != nil {
+			return result, fmt.Errorf("%s: %w", name, errs[i])
+		}
+		switch outcomes[i] {
+		case indexed:
+			result.indexed = append(result.indexed, name)
+		case skipped:
+			result.skipped = append(result.skipped, name)
+		case missing:
+			result.missing = append(result.missing, name)
+		}
+	}
+	return result, nil
+}
+
+// fileExists returns true when path exists.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// writeIndex indexes the object for hour in src and writes the index to path.
+func writeIndex(ctx context.Context, src gharchive.Source, layout gharchive.ObjectLayout, hour time.Time, path string) error {
+	index, err := gharchive.IndexHour(ctx, hour, &gharchive.Options{
+		Source:       src,
+		ObjectLayout: layout,
+	})
+	if err != nil {
+		return err
+	}
+	return writeIndexFile(path, index)
+}
+
+// writeSeekIndex builds the seek index for the named object in src and writes it to path.
+func writeSeekIndex(ctx context.Context, src gharchive.Source, name, path string) error {
+	rdr, err := src.Open(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rdr.Close() //nolint:errcheck // read only
+	}()
+	index, err := gharchive.BuildSeekIndex(rdr, name)
+	if err != nil {
+		return err
+	}
+	return writeIndexFile(path, index)
+}
+
+// writeIndexFile writes index to path by way of a part file.
+func writeIndexFile(path string, index io.WriterTo) (err error) {
+	partName := path + partSuffix
+	out, err := os.Create(partName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = out.Close() //nolint:errcheck // already closed on success
+		if err != nil {
+			_ = os.Remove(partName) //nolint:errcheck // the original error is more useful
+		}
+	}()
+	_, err = index.WriteTo(out)
+	if err != nil {
+		return err
+	}
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(partName, path)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/willabides/gharchive-client"
+)
+
+func Test_indexHours(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	end := start.Add(3 * time.Hour)
+	_, src := testSource(t, start, end, start.Add(time.Hour))
+	dest := t.TempDir()
+	opts := &indexOptions{
+		layout:      gharchive.DateLayout{},
+		start:       start,
+		end:         end,
+		dest:        dest,
+		concurrency: 2,
+	}
+	name := func(i int) string {
+		return gharchive.ObjectName(start.Add(time.Duration(i) * time.Hour))
+	}
+
+	result, err := indexHours(ctx, src, opts)
+	require.NoError(t, err)
+	require.Equal(t, []string{name(0), name(2)}, result.indexed)
+	require.Equal(t, []string{name(1)}, result.missing)
+	for _, i := range []int{0, 2} {
+		file, err := os.Open(filepath.Join(dest, gharchive.IndexName(name(i))))
+		require.NoError(t, err)
+		index, err := gharchive.ReadHourIndex(file)
+		require.NoError(t, file.Close())
+		require.NoError(t, err)
+		require.Equal(t, int64(20), index.Lines)
+	}
+	require.NoFileExists(t, filepath.Join(dest, gharchive.IndexName(name(1))))
+
+	result, err = indexHours(ctx, src, opts)
+	require.NoError(t, err)
+	require.Empty(t, result.indexed)
+	require.Equal(t, []string{name(0), name(2)}, result.skipped)
+
+	// hours without seek index files are indexed again
+	opts.seek = true
+	result, err = indexHours(ctx, src, opts)
+	require.NoError(t, err)
+	require.Equal(t, []string{name(0), name(2)}, result.indexed)
+	for _, i := range []int{0, 2} {
+		file, err := os.Open(filepath.Join(dest, gharchive.SeekIndexName(name(i))))
+		require.NoError(t, err)
+		index, err := gharchive.ReadSeekIndex(file)
+		require.NoError(t, file.Close())
+		require.NoError(t, err)
+		require.Equal(t, name(i), index.Object)
+		require.NotEmpty(t, index.Checkpoints)
+	}
+
+	opts.rebuild = true
+	result, err = indexHours(ctx, src, opts)
+	require.NoError(t, err)
+	require.Equal(t, []string{name(0), name(2)}, result.indexed)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/willabides/gharchive-client"
+)
+
+type lsCmd struct {
+	timeRangeArgs
+	JSON        bool `kong:"name=json,help='output a json object for each hour'"`
+	Concurrency int  `kong:"default=16,help='number of hours to query at once'"`
+}
+
+func (c *lsCmd) Run() error {
+	ctx := context.Background()
+	start, end, err := c.timeRange(24 * time.Hour)
+	if err != nil {
+		return err
+	}
+	opts := new(gharchive.Options)
+	src, err := gharchive.NewSource(ctx, opts)
+	if err != nil {
+		return err
+	}
+	objects, err := listHours(ctx, src, opts.Layout(), start, end, c.Concurrency)
+	if err != nil {
+		return err
+	}
+	if c.JSON {
+		return writeHourObjectsJSON(os.Stdout, objects)
+	}
+	return writeHourObjects(os.Stdout, objects)
+}
+
+// hourObject describes the object for an hour in a Source.
+type hourObject struct {
+	Hour    time.Time  `json:"hour"`
+	Name    string     `json:"name"`
+	Missing bool       `json:"missing"`
+	Size    int64      `json:"size,omitempty"`
+	CRC32C  string     `json:"crc32c,omitempty"`
+	Updated *time.Time `json:"updated,omitempty"`
+}
+
+// listHours gets the attributes of the object for each period of layout from the one containing start up to end.
+// Up to concurrency requests run at once. Objects are returned in order.
+func listHours(ctx context.Context, src gharchive.Source, layout gharchive.ObjectLayout, start, end time.Time, concurrency int) ([]hourObject, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var objects []hourObject
+	for hour := start.UTC().Truncate(layout.Period()); hour.Before(end); hour = hour.Add(layout.Period()) {
+		objects = append(objects, hourObject{
+			Hour: hour,
+			Name: layout.ObjectName(hour),
+		})
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	for i := range objects {
+		obj := &objects[i]
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			attrs, err := src.Attrs(ctx, obj.Name)
+			switch {
+			case err == gharchive.ErrObjectNotExist:
+				obj.Missing = true
+			case err != nil:
+				errOnce.Do(func() {
+					firstErr = fmt.Errorf("error getting attributes for %s: %w", obj.Name, err)
+					cancel()
+				})
+			default:
+				updated := attrs.Updated.UTC()
+				obj.Size = attrs.Size
+				obj.CRC32C = fmt.Sprintf("%08x", attrs.CRC32C)
+				obj.Updated = &updated
+			}
+		}()
+	}
+	wg.Wait()
+	return objects, firstErr
+}
+
+func writeHourObjectsJSON(w io.Writer, objects []hourObject) error {
+	enc := json.NewEncoder(w)
+	for _, obj := range objects {
+		err := enc.Encode(obj)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeHourObjects(w io.Writer, objects []hourObject) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSIZE\tCRC32C\tLAST MODIFIED\t")
+	var missing int
+	var size int64
+	for _, obj := range objects {
+		if obj.Missing {
+			missing++
+			fmt.Fprintf(tw, "%s\t-\t-\t-\tMISSING\n", obj.Name)
+			continue
+		}
+		size += obj.Size
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t\n", obj.Name, obj.Size, obj.CRC32C, obj.Updated.Format(time.RFC3339))
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%d hours, %d missing, %d bytes\n", len(objects), missing, size)
+	return err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/willabides/gharchive-client"
+	"github.com/willabides/gharchive-client/gharchivetest"
+)
+
+// testSource returns a source for a gharchivetest.Server with the hours from start up to end except skip.
+func testSource(t *testing.T, start, end time.Time, skip time.Time) (*gharchivetest.Server, gharchive.Source) {
+	t.Helper()
+	ctx := context.Background()
+	server := gharchivetest.NewServer(nil)
+	t.Cleanup(server.Close)
+	for hour := start; hour.Before(end); hour = hour.Add(time.Hour) {
+		if hour.Equal(skip) {
+			continue
+		}
+		require.NoError(t, server.SetHourLines(hour, gharchivetest.SyntheticLines(hour, 20)))
+	}
+	client, err := server.Client(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, client.Close())
+	})
+	return server, gharchive.NewGCSSource(client, server.Bucket)
+}
+
+func Test_listHours(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	end := start.Add(3 * time.Hour)
+	_, src := testSource(t, start, end, start.Add(time.Hour))
+	objects, err := listHours(ctx, src, gharchive.DateLayout{}, start.Add(10*time.Minute), end, 2)
+	require.NoError(t, err)
+	require.Len(t, objects, 3)
+	for i, obj := range objects {
+		hour := start.Add(time.Duration(i) * time.Hour)
+		require.Equal(t, hour, obj.Hour)
+		require.Equal(t, gharchive.ObjectName(hour), obj.Name)
+		require.Equal(t, i == 1, obj.Missing)
+		if obj.Missing {
+			continue
+		}
+		data, err := gharchivetest.Gzip(gharchivetest.SyntheticLines(hour, 20))
+		require.NoError(t, err)
+		require.Equal(t, int64(len(data)), obj.Size)
+		require.Equal(t, fmt.Sprintf("%08x", crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))), obj.CRC32C)
+		require.NotNil(t, obj.Updated)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writeHourObjects(&buf, objects))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 5)
+	require.Contains(t, lines[2], "MISSING")
+	require.Contains(t, lines[4], "3 hours, 1 missing")
+
+	buf.Reset()
+	require.NoError(t, writeHourObjectsJSON(&buf, objects))
+	var got []hourObject
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var obj hourObject
+		require.NoError(t, dec.Decode(&obj))
+		got = append(got, obj)
+	}
+	require.Equal(t, objects, got)
+}
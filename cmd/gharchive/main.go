@@ -6,6 +6,7 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"os"
 	"runtime"
 	"strings"
@@ -14,88 +15,242 @@ import (
 	"github.com/alecthomas/kong"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/willabides/gharchive-client"
+	"github.com/willabides/gharchive-client/gharchiveprom"
 	"golang.org/x/text/language"
 	"golang.org/x/text/message"
 )
 
-var cli struct {
-	Start           string   `kong:"arg,help='start time formatted as YYYY-MM-DD, or as an RFC3339 date'"`
-	End             string   `kong:"arg,optional,help='end time formatted as YYYY-MM-DD, or as an RFC3339 date. default is an hour past start'"`
-	IncludeType     []string `kong:"name=type,help='include only these event types'"`
-	ExcludeType     []string `kong:"name=not-type,help='exclude these event types'"`
-	StrictCreatedAt bool     `kong:"help='only output events with a created_at between start and end'"`
-	NoEmptyLines    bool     `kong:"help='skip empty lines'"`
-	OnlyValidJSON   bool     `kong:"help='skip lines that aren not valid json objects'"`
-	PreserveOrder   bool     `kong:"help='ensure that events are output in the same order they exist on data.gharchive.org'"`
-	Concurrency     int      `kong:"help='max number of concurrent downloads to run. Ignored if --preserve-order is set. Default is the number of cpus available.'"`
-	Debug           bool     `kong:"help='output debug logs'"`
+// scanArgs are the arguments for scanning events, which is what gharchive does without a command.
+type scanArgs struct {
+	timeRangeArgs
+	IncludeType       []string      `kong:"name=type,help='include only these event types'"`
+	ExcludeType       []string      `kong:"name=not-type,help='exclude these event types'"`
+	Repo              []string      `kong:"help='include only events for these repos like owner/name'"`
+	Actor             []string      `kong:"help='include only events by these actor logins'"`
+	Org               []string      `kong:"help='include only events for repos in these orgs'"`
+	StrictCreatedAt   bool          `kong:"help='only output events with a created_at at or after start and before end'"`
+	NoEmptyLines      bool          `kong:"help='skip empty lines'"`
+	OnlyValidJSON     bool          `kong:"help='skip lines that aren not valid json objects'"`
+	NormalizeLegacy   bool          `kong:"help='convert events from before 2015 to the modern format with actor, repo, org, payload and created_at fields'"`
+	OnCorruption      string        `kong:"enum='fail,skip-hour,resync',default=fail,help='what to do when an hour file is corrupt or truncated. fail, skip-hour or resync at the next gzip member. Skipped data is reported on stderr.'"`
+	PreserveOrder     bool          `kong:"help='ensure that events are output in the same order they exist on data.gharchive.org'"`
+	Concurrency       int           `kong:"help='max number of concurrent downloads to run. Ignored if --preserve-order is set. Default is the number of cpus available.'"`
+	FilterConcurrency int           `kong:"help='number of goroutines to run filters on. Filters run in a separate stage when this is greater than 1, which helps when --preserve-order is set.'"`
+	DecodeConcurrency int           `kong:"help='when greater than 1, download, decompress and split each hour file into lines on separate goroutines and decompress up to this many zstd frames at once. Helps most with --preserve-order and zstd files from gharchive recompress.'"`
+	Sample            float64       `kong:"help='output only this fraction (between 0 and 1) of events. The same events are output every time.'"`
+	SampleKey         string        `kong:"help='dot-separated path to a field like repo.name. With --sample, output all events for a fraction of the values of this field instead of a fraction of all events.'"`
+	SampleSeed        uint64        `kong:"help='seed for --sample. Use a different seed to get a different sample.'"`
+	Dedupe            bool          `kong:"help='skip events with an id that has already been output'"`
+	DedupeWindow      time.Duration `kong:"default=2h,help='how far apart the created_at values of duplicate events can be and still be caught by --dedupe'"`
+	Dir               string        `kong:"type=existingdir,help='read hour files from this directory, like one written by gharchive mirror, instead of data.gharchive.org. .json.zst files are read in place of missing .json.gz files.'"`
+	IndexDir          string        `kong:"type=existingdir,help='with --repo, --actor, --org or --type, skip hours that their index file from gharchive index in this directory shows cannot match. With --strict-created-at, start reading the first hour close to start when it has a seek index file. Default is --dir.'"`
+	MaxBuffer         int64         `kong:"help='max bytes of events to buffer ahead of output when running concurrent downloads. Default is no limit.'"`
+	Progress          bool          `kong:"help='show progress with throughput and an ETA on stderr'"`
+	MetricsAddr       string        `kong:"help='serve prometheus metrics at /metrics on this address while running. like localhost:9090'"`
+	Debug             bool          `kong:"help='output debug logs'"`
 }
 
-func parseTimeString(st string) (tm time.Time, err error) {
-	tm, err = time.Parse(time.RFC3339, st)
-	if err == nil {
-		return tm, nil
+var cli scanArgs
+
+// commands are run as "gharchive <command>". Running gharchive without a command scans events.
+var commands struct {
+	Generate   generateCmd   `kong:"cmd,help='write synthetic gharchive hour files'"`
+	Index      indexCmd      `kong:"cmd,help='write index files that let scans with --repo, --actor, --org or --type skip hours'"`
+	Ls         lsCmd         `kong:"cmd,help='list the hour files from start up to end with their sizes and checksums'"`
+	Mirror     mirrorCmd     `kong:"cmd,help='download the raw hour files from start up to end to a directory'"`
+	Recompress recompressCmd `kong:"cmd,help='convert mirrored hour files from gzip to zstd, which is faster to scan'"`
+	Verify     verifyCmd     `kong:"cmd,help='check mirrored hour files against the bucket and confirm they decode to json lines'"`
+}
+
+func commandNames() []string {
+	var names []string
+	for _, node := range kong.Must(&commands).Model.Children {
+		names = append(names, node.Name)
 	}
-	tm, err = time.ParseInLocation(`2006-01-02`, st, time.UTC)
-	if err != nil {
-		return tm, fmt.Errorf("invalid time")
+	return names
+}
+
+func isCommand(arg string) bool {
+	for _, name := range commandNames() {
+		if arg == name {
+			return true
+		}
 	}
-	return tm, nil
+	return false
+}
+
+// parseArgs parses os.Args into grammar like kong.Parse except that times relative to now like -6h aren't flags.
+func parseArgs(grammar interface{}, options ...kong.Option) *kong.Context {
+	parser := kong.Must(grammar, options...)
+	k, err := parser.Parse(escapeRelativeArgs(os.Args[1:]))
+	parser.FatalIfErrorf(err)
+	return k
 }
 
 func main() {
-	k := kong.Parse(&cli)
-	start, err := parseTimeString(cli.Start)
-	k.FatalIfErrorf(err, "invalid start time")
+	if len(os.Args) > 1 && isCommand(os.Args[1]) {
+		k := parseArgs(&commands)
+		k.FatalIfErrorf(k.Run())
+		return
+	}
+	k := parseArgs(&cli, kong.Description(
+		"Outputs events from gharchive. Other commands: "+strings.Join(commandNames(), ", "),
+	))
+	start, end, err := cli.timeRange(24 * time.Hour)
+	k.FatalIfErrorf(err, "invalid time range")
 	debugLog := log.New(ioutil.Discard, "DEBUG ", log.LstdFlags)
 	if cli.Debug {
 		debugLog.SetOutput(os.Stderr)
 	}
-	var end time.Time
-	if cli.End != "" {
-		end, err = parseTimeString(cli.End)
-		k.FatalIfErrorf(err, "invalid end time. must be either 'YYYY-MM-DD' or 'YYYY-MM-DDThh:mm:ssZ' (RFC 3339")
-	}
-	if end.IsZero() {
-		end = start.AddDate(0, 0, 1)
-	}
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	var validators []gharchive.Validator
-	if cli.NoEmptyLines {
-		validators = append(validators, gharchive.ValidateNotEmpty())
+	filters := cli.filters()
+	if cli.Concurrency == 0 {
+		cli.Concurrency = runtime.NumCPU()
 	}
-	if cli.OnlyValidJSON {
-		validators = append(validators, func(line []byte) bool {
-			return jsoniter.ConfigFastest.Valid(line)
+	if cli.PreserveOrder {
+		cli.Concurrency = 1
+	}
+	debugLog.Printf("concurrency=%d", cli.Concurrency)
+	debugLog.Printf("start=%s", start.Format(time.RFC3339))
+	debugLog.Printf("end=%s", end.Format(time.RFC3339))
+	var dedupe *gharchive.DedupeOptions
+	if cli.Dedupe {
+		dedupe = &gharchive.DedupeOptions{
+			Window: cli.DedupeWindow,
+		}
+	}
+	var progress func(gharchive.Progress)
+	var progressPrinter *progressPrinter
+	if cli.Progress {
+		progressPrinter = newProgressPrinter(os.Stderr)
+		progress = progressPrinter.update
+	}
+	var logHandler slog.Handler
+	if cli.Debug {
+		logHandler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+	}
+	onCorruption := map[string]gharchive.CorruptionPolicy{
+		"fail":      gharchive.CorruptionFail,
+		"skip-hour": gharchive.CorruptionSkipHour,
+		"resync":    gharchive.CorruptionResync,
+	}[cli.OnCorruption]
+	var metrics gharchive.Metrics
+	if cli.MetricsAddr != "" {
+		collector := gharchiveprom.NewCollector(&gharchiveprom.Options{
+			ValidatorNames: filters.names,
 		})
+		k.FatalIfErrorf(serveMetrics(cli.MetricsAddr, collector, debugLog), "error serving metrics")
+		metrics = collector
 	}
-	var fieldValidators []gharchive.JSONFieldValidator
-	if cli.StrictCreatedAt {
-		fieldValidators = append(fieldValidators, gharchive.JSONFieldValidator{
-			Field: "created_at",
-			Validator: gharchive.TimeValueValidator(func(val time.Time) bool {
-				if val.After(end) {
-					cancel()
-					return false
-				}
-				if val.Equal(start) || val.Equal(end) {
-					return true
-				}
-				return val.After(start) && val.Before(end)
-			}),
+	var source, indexSource gharchive.Source
+	if cli.Dir != "" {
+		source = gharchive.NewDirSource(cli.Dir)
+		indexSource = source
+	}
+	if cli.IndexDir != "" {
+		indexSource = gharchive.NewDirSource(cli.IndexDir)
+	}
+	var indexFilter map[string][]string
+	if indexSource != nil {
+		indexFilter = filters.indexFilter
+	}
+	sc, err := gharchive.New(ctx, start, &gharchive.Options{
+		Validators:            filters.validators,
+		Concurrency:           cli.Concurrency,
+		DecodeConcurrency:     cli.DecodeConcurrency,
+		PreserveOrder:         cli.PreserveOrder,
+		StrictTimeRange:       cli.StrictCreatedAt,
+		NormalizeLegacy:       cli.NormalizeLegacy,
+		EndTime:               end,
+		MaxBufferedBytes:      cli.MaxBuffer,
+		ValidationConcurrency: cli.FilterConcurrency,
+		Dedupe:                dedupe,
+		Progress:              progress,
+		Metrics:               metrics,
+		Logger:                logHandler,
+		OnCorruption:          onCorruption,
+		ReportCorruption:      reportCorruption,
+		Source:                source,
+		IndexFilter:           indexFilter,
+		IndexSource:           indexSource,
+		SeekIndex:             cli.StrictCreatedAt && indexSource != nil,
+	})
+	k.FatalIfErrorf(err, "error creating scanner")
+	defer func() {
+		_ = sc.Close() //nolint:errcheck // nothing to do with this error
+	}()
+	var lineCount int
+	scanStartTime := time.Now()
+	for sc.Scan(ctx) {
+		lineCount++
+		fmt.Print(string(sc.Bytes()))
+	}
+	if progressPrinter != nil {
+		progressPrinter.finish()
+	}
+	scanDuration := time.Since(scanStartTime)
+	linesPerSecond := int64(float64(lineCount) / scanDuration.Seconds())
+	debugLog.Println("done")
+	debugLog.Printf("output %s lines", message.NewPrinter(language.English).Sprintf("%d", lineCount))
+	debugLog.Printf("took %0.2f seconds", scanDuration.Seconds())
+	debugLog.Printf("output %s lines per second", message.NewPrinter(language.English).Sprintf("%d", linesPerSecond))
+	for i, stats := range sc.ValidatorStats() {
+		debugLog.Printf("filter %s rejected %s of %s lines", filters.names[i],
+			message.NewPrinter(language.English).Sprintf("%d", stats.Rejected),
+			message.NewPrinter(language.English).Sprintf("%d", stats.Evaluated),
+		)
+	}
+	if filters.fields != nil {
+		for _, stats := range filters.fields.FieldStats() {
+			debugLog.Printf("field %s rejected %s of %s values and %s lines without the field", stats.Field,
+				message.NewPrinter(language.English).Sprintf("%d", stats.Rejected),
+				message.NewPrinter(language.English).Sprintf("%d", stats.Evaluated),
+				message.NewPrinter(language.English).Sprintf("%d", stats.Missing),
+			)
+		}
+	}
+
+	err = sc.Err()
+	if err == io.EOF || err == context.Canceled {
+		err = nil
+	}
+	k.FatalIfErrorf(err, "error streaming from gharchive")
+}
+
+// scanFilters are the validators for a scan's filter flags.
+type scanFilters struct {
+	validators  []gharchive.Validator
+	names       []string                       // label validators in metrics
+	fields      *gharchive.JSONFieldsValidator // checks --type and --not-type. nil without them.
+	indexFilter map[string][]string            // values of indexed fields to include. nil without any.
+}
+
+// filters returns the validators for the filter flags in a. It adds the Event suffix to --type and --not-type
+// values that don't have it.
+func (a *scanArgs) filters() *scanFilters {
+	f := new(scanFilters)
+	if a.NoEmptyLines {
+		f.validators = append(f.validators, gharchive.ValidateNotEmpty())
+		f.names = append(f.names, "no-empty-lines")
+	}
+	if a.OnlyValidJSON {
+		f.validators = append(f.validators, func(line []byte) bool {
+			return jsoniter.ConfigFastest.Valid(line)
 		})
+		f.names = append(f.names, "only-valid-json")
 	}
-	if len(cli.IncludeType) > 0 {
-		for i, s := range cli.IncludeType {
+	var fieldValidators []gharchive.JSONFieldValidator
+	if len(a.IncludeType) > 0 {
+		for i, s := range a.IncludeType {
 			if !strings.HasSuffix(strings.ToLower(s), "event") {
-				cli.IncludeType[i] = s + "Event"
+				a.IncludeType[i] = s + "Event"
 			}
 		}
 		fieldValidators = append(fieldValidators, gharchive.JSONFieldValidator{
 			Field: "type",
 			Validator: gharchive.StringValueValidator(func(val string) bool {
-				for _, s := range cli.IncludeType {
+				for _, s := range a.IncludeType {
 					if strings.EqualFold(s, val) {
 						return true
 					}
@@ -104,16 +259,16 @@ func main() {
 			}),
 		})
 	}
-	if len(cli.ExcludeType) > 0 {
-		for i, s := range cli.ExcludeType {
+	if len(a.ExcludeType) > 0 {
+		for i, s := range a.ExcludeType {
 			if !strings.HasSuffix(strings.ToLower(s), "event") {
-				cli.ExcludeType[i] = s + "Event"
+				a.ExcludeType[i] = s + "Event"
 			}
 		}
 		fieldValidators = append(fieldValidators, gharchive.JSONFieldValidator{
 			Field: "type",
 			Validator: gharchive.StringValueValidator(func(val string) bool {
-				for _, s := range cli.ExcludeType {
+				for _, s := range a.ExcludeType {
 					if strings.EqualFold(s, val) {
 						return false
 					}
@@ -122,44 +277,51 @@ func main() {
 			}),
 		})
 	}
-	if len(fieldValidators) > 0 {
-		validators = append(validators, gharchive.ValidateJSONFields(fieldValidators))
+	fieldValues := map[string][]string{}
+	for field, values := range map[string][]string{
+		"repo.name":   a.Repo,
+		"actor.login": a.Actor,
+		"org.login":   a.Org,
+	} {
+		if len(values) > 0 {
+			fieldValues[field] = values
+		}
 	}
-	if cli.Concurrency == 0 {
-		cli.Concurrency = runtime.NumCPU()
+	if len(fieldValues) > 0 {
+		f.validators = append(f.validators, gharchive.ValidateFieldValues(fieldValues))
+		f.names = append(f.names, "repo-actor-org")
 	}
-	if cli.PreserveOrder {
-		cli.Concurrency = 1
+	// the index filter also skips hours without the --type values, which fieldValidators checks
+	if len(fieldValues) > 0 || len(a.IncludeType) > 0 {
+		f.indexFilter = make(map[string][]string, len(fieldValues)+1)
+		for field, values := range fieldValues {
+			f.indexFilter[field] = values
+		}
+		if len(a.IncludeType) > 0 {
+			f.indexFilter["type"] = a.IncludeType
+		}
 	}
-	debugLog.Printf("concurrency=%d", cli.Concurrency)
-	debugLog.Printf("start=%s", start.Format(time.RFC3339))
-	debugLog.Printf("end=%s", end.Format(time.RFC3339))
-	sc, err := gharchive.New(ctx, start, &gharchive.Options{
-		Validators:    validators,
-		Concurrency:   cli.Concurrency,
-		PreserveOrder: cli.PreserveOrder,
-		EndTime:       end,
-	})
-	k.FatalIfErrorf(err, "error creating scanner")
-	defer func() {
-		_ = sc.Close() //nolint:errcheck // nothing to do with this error
-	}()
-	var lineCount int
-	scanStartTime := time.Now()
-	for sc.Scan(ctx) {
-		lineCount++
-		fmt.Print(string(sc.Bytes()))
+	if len(fieldValidators) > 0 {
+		f.fields = gharchive.NewJSONFieldsValidator(fieldValidators)
+		f.validators = append(f.validators, f.fields.Validate)
+		f.names = append(f.names, "type")
 	}
-	scanDuration := time.Since(scanStartTime)
-	linesPerSecond := int64(float64(lineCount) / scanDuration.Seconds())
-	debugLog.Println("done")
-	debugLog.Printf("output %s lines", message.NewPrinter(language.English).Sprintf("%d", lineCount))
-	debugLog.Printf("took %0.2f seconds", scanDuration.Seconds())
-	debugLog.Printf("output %s lines per second", message.NewPrinter(language.English).Sprintf("%d", linesPerSecond))
+	if a.Sample > 0 {
+		if a.SampleKey == "" {
+			f.validators = append(f.validators, gharchive.ValidateSample(a.Sample, a.SampleSeed))
+		} else {
+			f.validators = append(f.validators, gharchive.ValidateSampleByField(a.SampleKey, a.Sample, a.SampleSeed))
+		}
+		f.names = append(f.names, "sample")
+	}
+	return f
+}
 
-	err = sc.Err()
-	if err == io.EOF || err == context.Canceled {
-		err = nil
+// reportCorruption writes a line about skipped corrupt data to stderr.
+func reportCorruption(e *gharchive.CorruptionError) {
+	resumed := "skipped the rest of the hour"
+	if e.Resumed != -1 {
+		resumed = fmt.Sprintf("resumed at offset %d", e.Resumed)
 	}
-	k.FatalIfErrorf(err, "error streaming from gharchive")
+	fmt.Fprintf(os.Stderr, "%s: %s\n", e, resumed)
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/alecthomas/kong"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_scanArgs_filters(t *testing.T) {
+	// filtersFor parses args the way main does and returns their filters
+	filtersFor := func(t *testing.T, args ...string) *scanFilters {
+		t.Helper()
+		a := cli
+		parser, err := kong.New(&a)
+		require.NoError(t, err)
+		_, err = parser.Parse(escapeRelativeArgs(args))
+		require.NoError(t, err)
+		return a.filters()
+	}
+	// accepts returns true when every validator in f accepts line
+	accepts := func(f *scanFilters, line string) bool {
+		for _, validate := range f.validators {
+			if !validate([]byte(line)) {
+				return false
+			}
+		}
+		return true
+	}
+
+	t.Run("type with repo", func(t *testing.T) {
+		f := filtersFor(t, "2020-10-10", "--repo", "org/repo", "--type", "push")
+		require.Equal(t, []string{"repo-actor-org", "type"}, f.names)
+		require.Equal(t, map[string][]string{
+			"repo.name": {"org/repo"},
+			"type":      {"pushEvent"},
+		}, f.indexFilter)
+		require.True(t, accepts(f, `{"type":"PushEvent","repo":{"name":"Org/Repo"}}`))
+		require.False(t, accepts(f, `{"type":"WatchEvent","repo":{"name":"org/repo"}}`))
+		require.False(t, accepts(f, `{"type":"PushEvent","repo":{"name":"org/other"}}`))
+	})
+
+	t.Run("type alone", func(t *testing.T) {
+		f := filtersFor(t, "2020-10-10", "--type", "PushEvent", "--not-type", "watch")
+		require.Equal(t, []string{"type"}, f.names)
+		require.Equal(t, map[string][]string{
+			"type": {"PushEvent"},
+		}, f.indexFilter)
+		require.True(t, accepts(f, `{"type":"PushEvent"}`))
+		require.False(t, accepts(f, `{"type":"WatchEvent"}`))
+	})
+
+	t.Run("no field filters", func(t *testing.T) {
+		f := filtersFor(t, "-6h", "--no-empty-lines")
+		require.Equal(t, []string{"no-empty-lines"}, f.names)
+		require.Nil(t, f.indexFilter)
+		require.Nil(t, f.fields)
+	})
+}
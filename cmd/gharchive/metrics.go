@@ -0,0 +1,31 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// serveMetrics serves collector at /metrics on addr in the background. It returns an error when it can't listen on addr.
+func serveMetrics(addr string, collector prometheus.Collector, debugLog *log.Logger) error {
+	registry := prometheus.NewRegistry()
+	err := registry.Register(collector)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	debugLog.Printf("serving metrics at http://%s/metrics", listener.Addr())
+	go func() {
+		serveErr := http.Serve(listener, mux)
+		debugLog.Printf("metrics server stopped: %v", serveErr)
+	}()
+	return nil
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/willabides/gharchive-client"
+)
+
+type mirrorCmd struct {
+	timeRangeArgs
+	Dest             string `kong:"required,type=path,help='directory to mirror hour files to'"`
+	Concurrency      int    `kong:"default=4,help='number of files to download at once'"`
+	DeleteExtraneous bool   `kong:"help='delete hour files in dest for hours in the range that no longer exist in the bucket'"`
+}
+
+func (c *mirrorCmd) Run() error {
+	ctx := context.Background()
+	start, end, err := c.timeRange(24 * time.Hour)
+	if err != nil {
+		return err
+	}
+	opts := new(gharchive.Options)
+	src, err := gharchive.NewSource(ctx, opts)
+	if err != nil {
+		return err
+	}
+	result, err := mirror(ctx, src, &mirrorOptions{
+		layout:           opts.Layout(),
+		start:            start,
+		end:              end,
+		dest:             c.Dest,
+		concurrency:      c.Concurrency,
+		deleteExtraneous: c.DeleteExtraneous,
+	})
+	if result == nil {
+		return err
+	}
+	fmt.Printf("downloaded %d, skipped %d, missing %d, deleted %d\n",
+		len(result.downloaded), len(result.skipped), len(result.missing), len(result.deleted))
+	return err
+}
+
+type mirrorOptions struct {
+	layout           gharchive.ObjectLayout
+	start, end       time.Time
+	dest             string
+	concurrency      int
+	deleteExtraneous bool
+}
+
+// mirrorResult lists the names of files that mirror handled in hour order.
+type mirrorResult struct {
+	downloaded []string
+	skipped    []string // already in dest with the right size and checksum or as a zstd file from gharchive recompress
+	missing    []string // not in the source
+	deleted    []string // in name order
+}
+
+// partSuffix is added to the names of files that are being downloaded. A part file left by an interrupted mirror is
+// resumed when the source supports range reads.
+const partSuffix = ".part"
+
+// mirror copies the raw objects from src for each period of opts.layout from the one containing start up to end to
+// opts.dest.
+func mirror(ctx context.Context, src gharchive.Source, opts *mirrorOptions) (*mirrorResult, error) {
+	err := os.MkdirAll(opts.dest, 0o750)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := listHours(ctx, src, opts.layout, opts.start, opts.end, opts.concurrency)
+	if err != nil {
+		return nil, err
+	}
+	skipped := make([]bool, len(objects))
+	errs := make([]error, len(objects))
+	runConcurrently(len(objects), opts.concurrency, func(i int) {
+		if !objects[i].Missing {
+			skipped[i], errs[i] = mirrorObject(ctx, src, opts.dest, objects[i])
+		}
+	})
+	result := new(mirrorResult)
+	for i, obj := range objects {
+		switch {
+		case errs[i] != nil:
+			return result, fmt.Errorf("%s: %w", obj.Name, errs[i])
+		case obj.Missing:
+			result.missing = append(result.missing, obj.Name)
+		case skipped[i]:
+			result.skipped = append(result.skipped, obj.Name)
+		default:
+			result.downloaded = append(result.downloaded, obj.Name)
+		}
+	}
+	if opts.deleteExtraneous {
+		result.deleted, err = deleteExtraneous(opts.dest, objects)
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// runConcurrently calls fn with each index up to count with up to concurrency calls running at once.
+func runConcurrently(count, concurrency int, fn func(i int)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		i := i
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}()
+	}
+	wg.Wait()
+}
+
+// mirrorObject downloads obj to dest unless dest already has it or its zstd variant. skipped is true when it didn't need
+// to download.
+func mirrorObject(ctx context.Context, src gharchive.Source, dest string, obj hourObject) (skipped bool, err error) {
+	local := gharchive.NewDirSource(dest)
+	err = checkObject(ctx, local, obj.Name, obj)
+	switch {
+	case err == nil:
+		return true, nil
+	case err == gharchive.ErrObjectNotExist && hasZstdVariant(dest, obj.Name):
+		// gharchive recompress --delete-gzip replaced it
+		return true, nil
+	case err != gharchive.ErrObjectNotExist && !errors.Is(err, gharchive.ErrChecksumMismatch):
+		return false, err
+	}
+	// retry once when the download doesn't match the source's checksum
+	for attempt := 0; ; attempt++ {
+		err = download(ctx, src, local, obj)
+		if err == nil || attempt > 0 || !errors.Is(err, gharchive.ErrChecksumMismatch) {
+			return false, err
+		}
+	}
+}
+
+// hasZstdVariant returns true when dest has the ZstdName variant of name.
+func hasZstdVariant(dest, name string) bool {
+	zstdName, ok := gharchive.ZstdName(name)
+	return ok && fileExists(filepath.Join(dest, zstdName))
+}
+
+// download downloads obj to dest by way of a part file and verifies its size and checksum. The file's modification
+// time is set to obj.Updated.
+func download(ctx context.Context, src gharchive.Source, dest *gharchive.DirSource, obj hourObject) error {
+	partName := obj.Name + partSuffix
+	partPath := filepath.Join(dest.Dir(), partName)
+	var offset int64
+	rangeSrc, canResume := src.(gharchive.RangeSource)
+	if canResume {
+		info, err := os.Stat(partPath)
+		if err == nil && info.Size() < obj.Size {
+			offset = info.Size()
+		}
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(partPath, flags, 0o640) //nolint:gosec // the name is from dest and an object name
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close() //nolint:errcheck // already closed on success
+	}()
+	var rdr io.ReadCloser
+	if offset > 0 {
+		rdr, err = rangeSrc.OpenRange(ctx, obj.Name, offset)
+	} else {
+		rdr, err = src.Open(ctx, obj.Name)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rdr.Close() //nolint:errcheck // nothing to do with this error
+	}()
+	_, err = io.Copy(file, rdr)
+	if err == nil {
+		err = file.Close()
+	}
+	if err == nil {
+		err = checkObject(ctx, dest, partName, obj)
+	}
+	if errors.Is(err, gharchive.ErrChecksumMismatch) {
+		removeErr := os.Remove(partPath)
+		if removeErr != nil {
+			return removeErr
+		}
+	}
+	if err != nil {
+		// other errors keep the part file so the next run can resume
+		return err
+	}
+	if obj.Updated != nil {
+		err = os.Chtimes(partPath, *obj.Updated, *obj.Updated)
+		if err != nil {
+			return err
+		}
+	}
+	return os.Rename(partPath, filepath.Join(dest.Dir(), obj.Name))
+}
+
+// checkObject returns a *gharchive.ChecksumError when the named file in dir doesn't have the size and checksum of
+// obj. The checksum is only computed when the size matches.
+func checkObject(ctx context.Context, dir *gharchive.DirSource, name string, obj hourObject) error {
+	want, err := strconv.ParseUint(obj.CRC32C, 16, 32)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(filepath.Join(dir.Dir(), name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return gharchive.ErrObjectNotExist
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size() != obj.Size {
+		return &gharchive.ChecksumError{
+			Name:       name,
+			WantSize:   obj.Size,
+			GotSize:    info.Size(),
+			WantCRC32C: uint32(want),
+		}
+	}
+	attrs, err := dir.Attrs(ctx, name)
+	if err != nil {
+		return err
+	}
+	if attrs.Size == obj.Size && attrs.CRC32C == uint32(want) {
+		return nil
+	}
+	return &gharchive.ChecksumError{
+		Name:       name,
+		WantSize:   obj.Size,
+		GotSize:    attrs.Size,
+		WantCRC32C: uint32(want),
+		GotCRC32C:  attrs.CRC32C,
+	}
+}
+
+// deleteExtraneous deletes the files in dest for objects that are missing from the source along with their zstd
+// variants and part files. Files for hours outside objects are left alone.
+func deleteExtraneous(dest string, objects []hourObject) ([]string, error) {
+	var deleted []string
+	for _, obj := range objects {
+		if !obj.Missing {
+			continue
+		}
+		names := []string{obj.Name, obj.Name + partSuffix}
+		if zstdName, ok := gharchive.ZstdName(obj.Name); ok {
+			names = append(names, zstdName, zstdName+partSuffix)
+		}
+		for _, name := range names {
+			err := os.Remove(filepath.Join(dest, name))
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return deleted, err
+			}
+			deleted = append(deleted, name)
+		}
+	}
+	sort.Strings(deleted)
+	return deleted, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/willabides/gharchive-client"
+	"github.com/willabides/gharchive-client/gharchivetest"
+)
+
+func Test_mirror(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	end := start.Add(4 * time.Hour)
+	_, src := testSource(t, start, end, start.Add(time.Hour))
+	dest := t.TempDir()
+	opts := &mirrorOptions{
+		layout:      gharchive.DateLayout{},
+		start:       start,
+		end:         end,
+		dest:        dest,
+		concurrency: 2,
+	}
+	hourData := func(hour time.Time) []byte {
+		t.Helper()
+		data, err := gharchivetest.Gzip(gharchivetest.SyntheticLines(hour, 20))
+		require.NoError(t, err)
+		return data
+	}
+	name := func(i int) string {
+		return gharchive.ObjectName(start.Add(time.Duration(i) * time.Hour))
+	}
+	zstdName := func(i int) string {
+		zstdName, ok := gharchive.ZstdName(name(i))
+		require.True(t, ok)
+		return zstdName
+	}
+	path := func(name string) string {
+		return filepath.Join(dest, name)
+	}
+	sorted := func(names []string) []string {
+		sort.Strings(names)
+		return names
+	}
+
+	result, err := mirror(ctx, src, opts)
+	require.NoError(t, err)
+	require.Equal(t, []string{name(0), name(2), name(3)}, result.downloaded)
+	require.Equal(t, []string{name(1)}, result.missing)
+	for _, i := range []int{0, 2, 3} {
+		got, err := os.ReadFile(filepath.Join(dest, name(i)))
+		require.NoError(t, err)
+		require.Equal(t, hourData(start.Add(time.Duration(i)*time.Hour)), got)
+		attrs, err := src.Attrs(ctx, name(i))
+		require.NoError(t, err)
+		info, err := os.Stat(path(name(i)))
+		require.NoError(t, err)
+		require.True(t, attrs.Updated.Equal(info.ModTime()))
+	}
+
+	// a corrupt file, a resumable part file, a corrupt part file and extraneous files
+	require.NoError(t, os.WriteFile(filepath.Join(dest, name(0)), []byte("corrupt"), 0o600))
+	data2 := hourData(start.Add(2 * time.Hour))
+	require.NoError(t, os.Remove(filepath.Join(dest, name(2))))
+	require.NoError(t, os.WriteFile(filepath.Join(dest, name(2)+partSuffix), data2[:len(data2)/2], 0o600))
+	require.NoError(t, os.Remove(filepath.Join(dest, name(3))))
+	require.NoError(t, os.WriteFile(filepath.Join(dest, name(3)+partSuffix), []byte("corrupt"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dest, name(1)), []byte("stale"), 0o600))
+	require.NoError(t, os.WriteFile(path(zstdName(1)), []byte("stale"), 0o600))
+	require.NoError(t, os.WriteFile(path(zstdName(0)), []byte("recompressed"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dest, name(5)+partSuffix), []byte("out of range"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dest, "notes.txt"), []byte("keep"), 0o600))
+
+	opts.deleteExtraneous = true
+	result, err = mirror(ctx, src, opts)
+	require.NoError(t, err)
+	require.Equal(t, []string{name(0), name(2), name(3)}, result.downloaded)
+	require.Equal(t, sorted([]string{name(1), zstdName(1)}), result.deleted)
+	for _, i := range []int{0, 2, 3} {
+		got, err := os.ReadFile(filepath.Join(dest, name(i)))
+		require.NoError(t, err)
+		require.Equal(t, hourData(start.Add(time.Duration(i)*time.Hour)), got)
+	}
+	entries, err := os.ReadDir(dest)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	require.Equal(t, sorted([]string{name(0), zstdName(0), name(2), name(3), name(5) + partSuffix, "notes.txt"}), names)
+
+	result, err = mirror(ctx, src, opts)
+	require.NoError(t, err)
+	require.Empty(t, result.downloaded)
+	require.Equal(t, []string{name(0), name(2), name(3)}, result.skipped)
+
+	// files with the right size are checksummed even when their modification time is unchanged
+	data3 := hourData(start.Add(3 * time.Hour))
+	info3, err := os.Stat(path(name(3)))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path(name(2)), make([]byte, len(data2)), 0o600))
+	require.NoError(t, os.WriteFile(path(name(3)), make([]byte, len(data3)), 0o600))
+	require.NoError(t, os.Chtimes(path(name(3)), info3.ModTime(), info3.ModTime()))
+	// a zstd file from gharchive recompress --delete-gzip stands in for its hour
+	require.NoError(t, os.Remove(path(name(0))))
+	result, err = mirror(ctx, src, opts)
+	require.NoError(t, err)
+	require.Equal(t, []string{name(2), name(3)}, result.downloaded)
+	require.Equal(t, []string{name(0)}, result.skipped)
+	require.Empty(t, result.deleted)
+	require.False(t, fileExists(path(name(0))))
+}
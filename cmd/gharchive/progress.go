@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/willabides/gharchive-client"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// progressPrinter writes a progress line with throughput and an ETA to w each time an hour is read.
+type progressPrinter struct {
+	w          io.Writer
+	start      time.Time
+	downloaded int64
+	emitted    int64
+	printer    *message.Printer
+}
+
+func newProgressPrinter(w io.Writer) *progressPrinter {
+	return &progressPrinter{
+		w:       w,
+		start:   time.Now(),
+		printer: message.NewPrinter(language.English),
+	}
+}
+
+func (p *progressPrinter) update(progress gharchive.Progress) {
+	p.downloaded += progress.BytesDownloaded
+	p.emitted += progress.LinesEmitted
+	elapsed := time.Since(p.start)
+	remaining := progress.HoursTotal - progress.HoursCompleted
+	eta := time.Duration(float64(elapsed) / float64(progress.HoursCompleted) * float64(remaining))
+	// \x1b[K clears whatever is left of the previous line
+	p.printer.Fprintf(p.w, "\r%d/%d hours  %.1f MB/s  %d lines/s  %d lines  ETA %s\x1b[K",
+		progress.HoursCompleted,
+		progress.HoursTotal,
+		float64(p.downloaded)/1e6/elapsed.Seconds(),
+		int64(float64(p.emitted)/elapsed.Seconds()),
+		p.emitted,
+		eta.Round(time.Second),
+	)
+}
+
+// finish ends the progress line.
+func (p *progressPrinter) finish() {
+	fmt.Fprintln(p.w)
+}
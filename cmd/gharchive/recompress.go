@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/willabides/gharchive-client"
+)
+
+type recompressCmd struct {
+	timeRangeArgs
+	Dir         string `kong:"required,type=existingdir,help='directory of .json.gz hour files like one written by gharchive mirror'"`
+	Dest        string `kong:"type=path,help='directory to write .json.zst files to. Default is --dir.'"`
+	Level       string `kong:"enum='fastest,default,better',default=default,help='zstd compression level. fastest, default or better.'"`
+	DeleteGzip  bool   `kong:"help='delete each .json.gz file once its .json.zst file is written and verified'"`
+	Concurrency int    `kong:"help='number of files to recompress at once. Default is the number of cpus available.'"`
+}
+
+func (c *recompressCmd) Run() error {
+	start, end, err := c.timeRange(24 * time.Hour)
+	if err != nil {
+		return err
+	}
+	_, level := zstd.EncoderLevelFromString(c.Level)
+	if c.Dest == "" {
+		c.Dest = c.Dir
+	}
+	if c.Concurrency == 0 {
+		c.Concurrency = runtime.NumCPU()
+	}
+	result, err := recompress(&recompressOptions{
+		layout:      new(gharchive.Options).Layout(),
+		start:       start,
+		end:         end,
+		dir:         c.Dir,
+		dest:        c.Dest,
+		level:       level,
+		frameSize:   defaultFrameSize,
+		deleteGzip:  c.DeleteGzip,
+		concurrency: c.Concurrency,
+	})
+	if result == nil {
+		return err
+	}
+	saved := 0.0
+	if result.gzipBytes > 0 {
+		saved = 100 * (1 - float64(result.zstdBytes)/float64(result.gzipBytes))
+	}
+	fmt.Printf("recompressed %d, skipped %d, missing %d. %d bytes of gzip became %d bytes of zstd (%0.1f%% smaller)\n",
+		len(result.recompressed), len(result.skipped), len(result.missing), result.gzipBytes, result.zstdBytes, saved)
+	return err
+}
+
+// defaultFrameSize is roughly how many bytes of lines go in each zstd frame. Frames start at line boundaries, so a
+// reader can resync at the next frame after corrupt data.
+const defaultFrameSize = 4 << 20
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+type recompressOptions struct {
+	layout      gharchive.ObjectLayout
+	start, end  time.Time
+	dir         string
+	dest        string
+	level       zstd.EncoderLevel
+	frameSize   int
+	deleteGzip  bool
+	concurrency int
+}
+
+// recompressResult lists the names of the gzip files that recompress handled in hour order.
+type recompressResult struct {
+	recompressed []string
+	skipped      []string // already have a zstd file in dest
+	missing      []string // not in dir
+	gzipBytes    int64    // size of the recompressed gzip files
+	zstdBytes    int64    // size of the zstd files that replace them
+}
+
+// recompress writes a zstd file to opts.dest for each gzip file in opts.dir for each period of opts.layout from the
+// one containing start up to end.
+func recompress(opts *recompressOptions) (*recompressResult, error) {
+	err := os.MkdirAll(opts.dest, 0o750)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	period := opts.layout.Period()
+	for hour := opts.start.UTC().Truncate(period); hour.Before(opts.end); hour = hour.Add(period) {
+		names = append(names, opts.layout.ObjectName(hour))
+	}
+	const (
+		recompressed = iota
+		skipped
+		missing
+	)
+	outcomes := make([]int, len(names))
+	gzipSizes := make([]int64, len(names))
+	zstdSizes := make([]int64, len(names))
+	errs := make([]error, len(names))
+	runConcurrently(len(names), opts.concurrency, func(i int) {
+		zstdName, ok := gharchive.ZstdName(names[i])
+		if !ok {
+			errs[i] = fmt.Errorf("%s doesn't have the %s extension", names[i], gharchive.GzipExt)
+			return
+		}
+		src := filepath.Join(opts.dir, names[i])
+		dst := filepath.Join(opts.dest, zstdName)
+		_, err := os.Stat(dst)
+		if err == nil {
+			outcomes[i] = skipped
+			return
+		}
+		_, err = os.Stat(src)
+		if os.IsNotExist(err) {
+			outcomes[i] = missing
+			return
+		}
+		gzipSizes[i], zstdSizes[i], errs[i] = recompressFile(src, dst, opts.level, opts.frameSize)
+		if errs[i] == nil && opts.deleteGzip {
+			errs[i] = os.Remove(src)
+		}
+	})
+	result := new(recompressResult)
+	for i, name := range names {
+		if errs[i] != nil {
+			return result, fmt.Errorf("%s: %w", name, errs[i])
+		}
+		switch outcomes[i] {
+		case recompressed:
+			result.recompressed = append(result.recompressed, name)
+			result.gzipBytes += gzipSizes[i]
+			result.zstdBytes += zstdSizes[i]
+		case skipped:
+			result.skipped = append(result.skipped, name)
+		case missing:
+			result.missing = append(result.missing, name)
+		}
+	}
+	return result, nil
+}
+
+// recompressFile decompresses the gzip file src and writes it to dst as zstd by way of a part file. It starts a new
+// frame after the first line that brings the current frame to frameSize bytes. dst is decompressed and compared to
+// src before it is moved into place.
+func recompressFile(src, dst string, level zstd.EncoderLevel, frameSize int) (gzipSize, zstdSize int64, err error) {
+	in, err := os.Open(src) //nolint:gosec // reading files in dir is the point
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		_ = in.Close() //nolint:errcheck // read only
+	}()
+	gzRdr, err := gzip.NewReader(in)
+	if err != nil {
+		return 0, 0, err
+	}
+	partName := dst + partSuffix
+	out, err := os.Create(partName)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		_ = out.Close() //nolint:errcheck // already closed on success
+		if err != nil {
+			_ = os.Remove(partName) //nolint:errcheck // the original error is more useful
+		}
+	}()
+	// frames are encoded whole so that their headers have the content size, which lets the scanner decompress them in
+	// parallel
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		_ = enc.Close() //nolint:errcheck // only used for EncodeAll
+	}()
+	hash := crc32.New(crc32cTable)
+	var size int64
+	var frame, compressed []byte
+	writeFrame := func() error {
+		compressed = enc.EncodeAll(frame, compressed[:0])
+		frame = frame[:0]
+		_, writeErr := out.Write(compressed)
+		return writeErr
+	}
+	br := bufio.NewReaderSize(gzRdr, 64*1024)
+	for {
+		line, readErr := br.ReadSlice('\n')
+		if readErr != nil && readErr != io.EOF && readErr != bufio.ErrBufferFull {
+			return 0, 0, readErr
+		}
+		frame = append(frame, line...)
+		_, _ = hash.Write(line) //nolint:errcheck // hashes don't return errors
+		size += int64(len(line))
+		if readErr == io.EOF {
+			break
+		}
+		if readErr == nil && len(frame) >= frameSize {
+			err = writeFrame()
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	// an empty hour still gets a frame so that the file is recognized as zstd
+	if len(frame) > 0 || size == 0 {
+		err = writeFrame()
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	err = out.Close()
+	if err != nil {
+		return 0, 0, err
+	}
+	err = checkZstdFile(partName, size, hash.Sum32())
+	if err != nil {
+		return 0, 0, err
+	}
+	err = os.Rename(partName, dst)
+	if err != nil {
+		return 0, 0, err
+	}
+	gzipInfo, err := in.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	zstdInfo, err := os.Stat(dst)
+	if err != nil {
+		return 0, 0, err
+	}
+	return gzipInfo.Size(), zstdInfo.Size(), nil
+}
+
+// checkZstdFile returns an error unless filename decompresses to size bytes with the CRC32C checksum sum.
+func checkZstdFile(filename string, size int64, sum uint32) error {
+	file, err := os.Open(filename) //nolint:gosec // the file was just written
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close() //nolint:errcheck // read only
+	}()
+	dec, err := zstd.NewReader(file, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return err
+	}
+	defer dec.Close()
+	hash := crc32.New(crc32cTable)
+	gotSize, err := io.Copy(hash, dec)
+	if err != nil {
+		return err
+	}
+	if gotSize != size || hash.Sum32() != sum {
+		return fmt.Errorf("%s decompressed to %d bytes with crc32c %08x, want %d bytes with crc32c %08x",
+			filename, gotSize, hash.Sum32(), size, sum)
+	}
+	return nil
+}
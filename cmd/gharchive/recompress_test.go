@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+	"github.com/willabides/gharchive-client"
+	"github.com/willabides/gharchive-client/gharchivetest"
+)
+
+func Test_recompress(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	end := start.Add(3 * time.Hour)
+	_, src := testSource(t, start, end.Add(-time.Hour), time.Time{})
+	dir := t.TempDir()
+	_, err := mirror(ctx, src, &mirrorOptions{
+		layout: gharchive.DateLayout{},
+		start:  start,
+		end:    end,
+		dest:   dir,
+	})
+	require.NoError(t, err)
+	opts := &recompressOptions{
+		layout:      gharchive.DateLayout{},
+		start:       start,
+		end:         end,
+		dir:         dir,
+		dest:        dir,
+		level:       zstd.SpeedDefault,
+		frameSize:   1000,
+		deleteGzip:  true,
+		concurrency: 2,
+	}
+	name := func(i int) string {
+		return gharchive.ObjectName(start.Add(time.Duration(i) * time.Hour))
+	}
+	result, err := recompress(opts)
+	require.NoError(t, err)
+	require.Equal(t, []string{name(0), name(1)}, result.recompressed)
+	require.Equal(t, []string{name(2)}, result.missing)
+	require.Greater(t, result.zstdBytes, int64(0))
+	for _, i := range []int{0, 1} {
+		require.NoFileExists(t, filepath.Join(dir, name(i)))
+		zstdName, ok := gharchive.ZstdName(name(i))
+		require.True(t, ok)
+		data, err := os.ReadFile(filepath.Join(dir, zstdName))
+		require.NoError(t, err)
+		require.Greater(t, bytes.Count(data, []byte{0x28, 0xb5, 0x2f, 0xfd}), 1, "want more than one frame")
+	}
+
+	// scanners read the zstd files in place of the gzip files
+	scanner, err := gharchive.New(ctx, start, &gharchive.Options{
+		Source:     gharchive.NewDirSource(dir),
+		EndTime:    end.Add(-time.Hour),
+		Validators: []gharchive.Validator{gharchive.ValidateNotEmpty()},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, scanner.Close())
+	})
+	var want, got []string
+	for _, i := range []int{0, 1} {
+		for _, line := range gharchivetest.SyntheticLines(start.Add(time.Duration(i)*time.Hour), 20) {
+			want = append(want, string(line))
+		}
+	}
+	for scanner.Scan(ctx) {
+		got = append(got, string(scanner.Bytes()))
+	}
+	require.NoError(t, scanner.Err())
+	require.Equal(t, want, got)
+
+	result, err = recompress(opts)
+	require.NoError(t, err)
+	require.Empty(t, result.recompressed)
+	require.Equal(t, []string{name(0), name(1)}, result.skipped)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// period is a calendar-aware length of time like the ones in ISO-8601 durations.
+type period struct {
+	years, months, days int
+	dur                 time.Duration
+}
+
+func (p period) addTo(tm time.Time) time.Time {
+	return tm.AddDate(p.years, p.months, p.days).Add(p.dur)
+}
+
+var isoDurationExp = regexp.MustCompile(`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseISODuration parses an ISO-8601 duration like P7D or PT6H.
+func parseISODuration(st string) (period, error) {
+	var p period
+	m := isoDurationExp.FindStringSubmatch(strings.ToUpper(st))
+	if m == nil || st == "P" || strings.HasSuffix(st, "T") {
+		return p, fmt.Errorf("invalid duration %q", st)
+	}
+	n := make([]int, len(m))
+	for i := 1; i < len(m); i++ {
+		if m[i] == "" {
+			continue
+		}
+		var err error
+		n[i], err = strconv.Atoi(m[i])
+		if err != nil {
+			return p, fmt.Errorf("invalid duration %q", st)
+		}
+	}
+	p.years = n[1]
+	p.months = n[2]
+	p.days = n[3]*7 + n[4]
+	p.dur = time.Duration(n[5])*time.Hour + time.Duration(n[6])*time.Minute + time.Duration(n[7])*time.Second
+	return p, nil
+}
+
+var relativeExp = regexp.MustCompile(`^([+-])(\d+)([dw])$`)
+
+// parseRelative parses a duration relative to now like -6h, -90m or -3d.
+func parseRelative(st string, now time.Time) (time.Time, bool) {
+	if !strings.HasPrefix(st, "-") && !strings.HasPrefix(st, "+") {
+		return time.Time{}, false
+	}
+	dur, err := time.ParseDuration(st)
+	if err == nil {
+		return now.Add(dur), true
+	}
+	m := relativeExp.FindStringSubmatch(st)
+	if m == nil {
+		return time.Time{}, false
+	}
+	n, err := strconv.Atoi(m[2])
+	if err != nil {
+		return time.Time{}, false
+	}
+	if m[3] == "w" {
+		n *= 7
+	}
+	if m[1] == "-" {
+		n = -n
+	}
+	return now.AddDate(0, 0, n), true
+}
+
+// escapeRelativeArgs returns args with a space in front of times relative to now like -6h or -3d/PT1H so that kong
+// parses them as positional arguments instead of flags. parseTime trims the space. Arguments after "--" are left as is.
+func escapeRelativeArgs(args []string) []string {
+	out := make([]string, len(args))
+	copy(out, args)
+	for i, arg := range out {
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if _, ok := parseRelative(strings.SplitN(arg, "/", 2)[0], time.Time{}); ok {
+			out[i] = " " + arg
+		}
+	}
+	return out
+}
+
+// layouts are time layouts without a time zone along with the length of the period each one names.
+var layouts = []struct {
+	layout string
+	span   period
+}{
+	{layout: "2006", span: period{years: 1}},
+	{layout: "2006-01", span: period{months: 1}},
+	{layout: "2006-01-02", span: period{days: 1}},
+	{layout: "2006-01-02T15", span: period{dur: time.Hour}},
+	{layout: "2006-01-02T15:04", span: period{dur: time.Minute}},
+	{layout: "2006-01-02T15:04:05", span: period{dur: time.Second}},
+}
+
+// parseTime parses a single time expression. Times without a zone are in loc. defaultEnd is the end of the
+// period that st names, or now for expressions relative to now. It is zero when st names an exact time.
+func parseTime(st string, now time.Time, loc *time.Location) (tm, defaultEnd time.Time, err error) {
+	st = strings.TrimSpace(st)
+	today := time.Date(now.In(loc).Year(), now.In(loc).Month(), now.In(loc).Day(), 0, 0, 0, 0, loc)
+	switch strings.ToLower(st) {
+	case "now":
+		return now, now, nil
+	case "today":
+		return today, today.AddDate(0, 0, 1), nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), today, nil
+	}
+	if tm, ok := parseRelative(st, now); ok {
+		return tm, now, nil
+	}
+	tm, err = time.Parse(time.RFC3339, st)
+	if err == nil {
+		return tm, time.Time{}, nil
+	}
+	for _, l := range layouts {
+		tm, err = time.ParseInLocation(l.layout, st, loc)
+		if err == nil {
+			return tm, l.span.addTo(tm), nil
+		}
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid time %q", st)
+}
+
+// parseTimeRange parses the start and end arguments. start may be an ISO-8601 interval like 2020-10-01/P7D or
+// 2020-10-01/2020-10-08, in which case end must be empty. When end is empty and start isn't an interval, end is the
+// end of the period start names. It is zero when start is an exact time.
+func parseTimeRange(start, end string, now time.Time, loc *time.Location) (startTime, endTime time.Time, err error) {
+	if strings.Contains(start, "/") {
+		if end != "" {
+			return startTime, endTime, fmt.Errorf("end can't be set when start is an interval")
+		}
+		parts := strings.SplitN(start, "/", 2)
+		startTime, _, err = parseTime(parts[0], now, loc)
+		if err != nil {
+			return startTime, endTime, err
+		}
+		if strings.HasPrefix(strings.ToUpper(parts[1]), "P") {
+			var p period
+			p, err = parseISODuration(parts[1])
+			if err != nil {
+				return startTime, endTime, err
+			}
+			return startTime, p.addTo(startTime), nil
+		}
+		endTime, _, err = parseTime(parts[1], now, loc)
+		return startTime, endTime, err
+	}
+	startTime, endTime, err = parseTime(start, now, loc)
+	if err != nil || end == "" {
+		return startTime, endTime, err
+	}
+	endTime, _, err = parseTime(end, now, loc)
+	return startTime, endTime, err
+}
+
+// timeRangeArgs are the arguments for commands that work on a range of time.
+type timeRangeArgs struct {
+	Start string `kong:"arg,help='start time. See the README for supported formats including YYYY-MM-DD, RFC3339, now, today, yesterday, -6h, 2020-10 and 2020-10-01/P7D'"`
+	End   string `kong:"arg,optional,help='end time. default is the end of the period start names, like the end of the day for YYYY-MM-DD'"`
+	TZ    string `kong:"name=tz,default=UTC,help='time zone to use for times that do not include one'"`
+}
+
+// timeRange returns the parsed start and end times. When neither start nor end determine the end time, it is
+// defaultSpan after start.
+func (a *timeRangeArgs) timeRange(defaultSpan time.Duration) (start, end time.Time, err error) {
+	loc, err := time.LoadLocation(a.TZ)
+	if err != nil {
+		return start, end, err
+	}
+	start, end, err = parseTimeRange(a.Start, a.End, time.Now(), loc)
+	if err != nil {
+		return start, end, err
+	}
+	if end.IsZero() {
+		end = start.Add(defaultSpan)
+	}
+	return start, end, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseTimeRange(t *testing.T) {
+	now := time.Date(2020, 10, 10, 8, 30, 0, 0, time.UTC)
+	date := func(year int, month time.Month, day, hour int) time.Time {
+		return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+	}
+	for _, td := range []struct {
+		start, end string
+		wantStart  time.Time
+		wantEnd    time.Time
+		wantErr    bool
+	}{
+		{start: "2020-10-01", wantStart: date(2020, 10, 1, 0), wantEnd: date(2020, 10, 2, 0)},
+		{start: "2020-10", wantStart: date(2020, 10, 1, 0), wantEnd: date(2020, 11, 1, 0)},
+		{start: "2020", wantStart: date(2020, 1, 1, 0), wantEnd: date(2021, 1, 1, 0)},
+		{start: "2020-10-01T05", wantStart: date(2020, 10, 1, 5), wantEnd: date(2020, 10, 1, 6)},
+		{start: "2020-10-01T05:00:00Z", wantStart: date(2020, 10, 1, 5)},
+		{start: "2020-10-01", end: "2020-10-03", wantStart: date(2020, 10, 1, 0), wantEnd: date(2020, 10, 3, 0)},
+		{start: "today", wantStart: date(2020, 10, 10, 0), wantEnd: date(2020, 10, 11, 0)},
+		{start: "yesterday", wantStart: date(2020, 10, 9, 0), wantEnd: date(2020, 10, 10, 0)},
+		{start: "-6h", wantStart: now.Add(-6 * time.Hour), wantEnd: now},
+		{start: "-3d", wantStart: now.AddDate(0, 0, -3), wantEnd: now},
+		{start: "-1w", end: "-2d", wantStart: now.AddDate(0, 0, -7), wantEnd: now.AddDate(0, 0, -2)},
+		{start: "2020-10-01/P7D", wantStart: date(2020, 10, 1, 0), wantEnd: date(2020, 10, 8, 0)},
+		{start: "2020-10-01T05/PT6H", wantStart: date(2020, 10, 1, 5), wantEnd: date(2020, 10, 1, 11)},
+		{start: "2020-10-01/2020-10-03", wantStart: date(2020, 10, 1, 0), wantEnd: date(2020, 10, 3, 0)},
+		{start: "2020-10-01/P7D", end: "2020-10-03", wantErr: true},
+		{start: "2020-10-01/P", wantErr: true},
+		{start: "last tuesday", wantErr: true},
+	} {
+		t.Run(td.start+" "+td.end, func(t *testing.T) {
+			gotStart, gotEnd, err := parseTimeRange(td.start, td.end, now, time.UTC)
+			if td.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, td.wantStart.Equal(gotStart), "start: %v", gotStart)
+			require.True(t, td.wantEnd.Equal(gotEnd), "end: %v", gotEnd)
+		})
+	}
+
+	t.Run("location", func(t *testing.T) {
+		loc := time.FixedZone("test", -7*60*60)
+		gotStart, gotEnd, err := parseTimeRange("2020-10-01", "", now, loc)
+		require.NoError(t, err)
+		require.True(t, date(2020, 10, 1, 7).Equal(gotStart))
+		require.True(t, date(2020, 10, 2, 7).Equal(gotEnd))
+	})
+}
+
+func Test_escapeRelativeArgs(t *testing.T) {
+	// parse parses args into grammar the way parseArgs does
+	parse := func(t *testing.T, grammar interface{}, args ...string) error {
+		t.Helper()
+		parser, err := kong.New(grammar)
+		require.NoError(t, err)
+		_, err = parser.Parse(escapeRelativeArgs(args))
+		return err
+	}
+	requireNear := func(t *testing.T, want, got time.Time) {
+		t.Helper()
+		require.WithinDuration(t, want, got, time.Minute)
+	}
+
+	t.Run("scan", func(t *testing.T) {
+		for _, td := range []struct {
+			args      []string
+			wantStart time.Duration
+			wantEnd   time.Duration
+		}{
+			{args: []string{"-6h"}, wantStart: -6 * time.Hour},
+			{args: []string{"-90m", "-30m", "--repo", "foo/bar"}, wantStart: -90 * time.Minute, wantEnd: -30 * time.Minute},
+			{args: []string{"--strict-created-at", "-3d", "-2d"}, wantStart: -72 * time.Hour, wantEnd: -48 * time.Hour},
+			{args: []string{"-2h/PT1H"}, wantStart: -2 * time.Hour, wantEnd: -time.Hour},
+			{args: []string{"--", "-6h"}, wantStart: -6 * time.Hour},
+		} {
+			t.Run(td.args[0], func(t *testing.T) {
+				c := cli
+				require.NoError(t, parse(t, &c, td.args...))
+				start, end, err := c.timeRange(24 * time.Hour)
+				require.NoError(t, err)
+				now := time.Now()
+				requireNear(t, now.Add(td.wantStart), start)
+				requireNear(t, now.Add(td.wantEnd), end)
+			})
+		}
+	})
+
+	t.Run("command", func(t *testing.T) {
+		c := commands
+		require.NoError(t, parse(t, &c, "ls", "-6h", "-1h"))
+		start, end, err := c.Ls.timeRange(24 * time.Hour)
+		require.NoError(t, err)
+		now := time.Now()
+		requireNear(t, now.Add(-6*time.Hour), start)
+		requireNear(t, now.Add(-time.Hour), end)
+	})
+
+	t.Run("flags", func(t *testing.T) {
+		c := cli
+		err := parse(t, &c, "-6x")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unknown flag -6")
+		c = cli
+		require.Error(t, parse(t, &c, "today", "--bogus"))
+	})
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/willabides/gharchive-client"
+)
+
+type verifyCmd struct {
+	timeRangeArgs
+	Dir         string `kong:"required,type=existingdir,help='directory of mirrored hour files to verify'"`
+	Concurrency int    `kong:"default=4,help='number of files to verify at once'"`
+}
+
+func (c *verifyCmd) Run() error {
+	ctx := context.Background()
+	start, end, err := c.timeRange(24 * time.Hour)
+	if err != nil {
+		return err
+	}
+	opts := new(gharchive.Options)
+	src, err := gharchive.NewSource(ctx, opts)
+	if err != nil {
+		return err
+	}
+	checks, err := verifyHours(ctx, src, gharchive.NewDirSource(c.Dir), opts.Layout(), start, end, c.Concurrency)
+	if err != nil {
+		return err
+	}
+	problems, err := writeHourChecks(os.Stdout, checks)
+	if err != nil {
+		return err
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d hours failed verification", problems)
+	}
+	return nil
+}
+
+// hourCheck is the result of verifying the local copy of an hour.
+type hourCheck struct {
+	Name    string
+	Missing bool   // the hour is in neither the bucket nor the local directory
+	Problem string // empty when the local copy is good
+}
+
+// verifyHours checks that the objects in local match the size and checksum of the same objects in src and that each
+// one decodes to valid NDJSON. An object that gharchive recompress replaced with its zstd variant can only be checked
+// for NDJSON.
+func verifyHours(ctx context.Context, src gharchive.Source, local *gharchive.DirSource, layout gharchive.ObjectLayout, start, end time.Time, concurrency int) ([]hourCheck, error) {
+	objects, err := listHours(ctx, src, layout, start, end, concurrency)
+	if err != nil {
+		return nil, err
+	}
+	checks := make([]hourCheck, len(objects))
+	errs := make([]error, len(objects))
+	runConcurrently(len(objects), concurrency, func(i int) {
+		checks[i], errs[i] = verifyHour(ctx, local, objects[i])
+	})
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", objects[i].Name, err)
+		}
+	}
+	return checks, nil
+}
+
+func verifyHour(ctx context.Context, local *gharchive.DirSource, obj hourObject) (hourCheck, error) {
+	check := hourCheck{Name: obj.Name}
+	if obj.Missing {
+		_, err := local.Attrs(ctx, obj.Name)
+		switch {
+		case err == gharchive.ErrObjectNotExist && hasZstdVariant(local.Dir(), obj.Name):
+			check.Problem = "not in bucket"
+		case err == gharchive.ErrObjectNotExist:
+			check.Missing = true
+		case err != nil:
+			return check, err
+		default:
+			check.Problem = "not in bucket"
+		}
+		return check, nil
+	}
+	name := obj.Name
+	isZstd := false
+	err := checkObject(ctx, local, obj.Name, obj)
+	switch {
+	case err == gharchive.ErrObjectNotExist && hasZstdVariant(local.Dir(), obj.Name):
+		// gharchive recompress replaced the mirrored object, so there is no checksum to compare with the bucket's.
+		name, _ = gharchive.ZstdName(obj.Name)
+		isZstd = true
+	case err == gharchive.ErrObjectNotExist:
+		check.Problem = "not mirrored"
+		return check, nil
+	case errors.Is(err, gharchive.ErrChecksumMismatch):
+		check.Problem = err.Error()
+		return check, nil
+	case err != nil:
+		return check, err
+	}
+	rdr, err := local.Open(ctx, name)
+	if err != nil {
+		return check, err
+	}
+	defer func() {
+		_ = rdr.Close() //nolint:errcheck // read only
+	}()
+	err = checkNDJSON(rdr, isZstd)
+	if err != nil {
+		check.Problem = err.Error()
+	}
+	return check, nil
+}
+
+// checkNDJSON returns an error when r isn't gzipped lines of json or zstd compressed lines of json when isZstd is
+// set. Empty lines are allowed.
+func checkNDJSON(r io.Reader, isZstd bool) error {
+	format := "gzip"
+	var decompressed io.Reader
+	if isZstd {
+		format = "zstd"
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return fmt.Errorf("invalid zstd: %w", err)
+		}
+		defer dec.Close()
+		decompressed = dec
+	} else {
+		gzRdr, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("invalid gzip: %w", err)
+		}
+		decompressed = gzRdr
+	}
+	br := bufio.NewReader(decompressed)
+	for lineNum := 1; ; lineNum++ {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("invalid %s: %w", format, err)
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 && !json.Valid(line) {
+			return fmt.Errorf("invalid json on line %d", lineNum)
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// writeHourChecks writes a line for each check and a summary. It returns the number of checks with problems.
+func writeHourChecks(w io.Writer, checks []hourCheck) (int, error) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTATUS")
+	var missing, problems int
+	for _, check := range checks {
+		status := "OK"
+		switch {
+		case check.Missing:
+			missing++
+			status = "MISSING"
+		case check.Problem != "":
+			problems++
+			status = check.Problem
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", check.Name, status)
+	}
+	err := tw.Flush()
+	if err != nil {
+		return problems, err
+	}
+	_, err = fmt.Fprintf(w, "%d hours, %d ok, %d missing, %d problems\n",
+		len(checks), len(checks)-missing-problems, missing, problems)
+	return problems, err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+	"github.com/willabides/gharchive-client"
+)
+
+func Test_verifyHours(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	end := start.Add(8 * time.Hour)
+	hour := func(i int) time.Time {
+		return start.Add(time.Duration(i) * time.Hour)
+	}
+	name := func(i int) string {
+		return gharchive.ObjectName(hour(i))
+	}
+	server, src := testSource(t, start, end, hour(1))
+	require.NoError(t, server.SetHourLines(hour(4), [][]byte{[]byte("{\"id\":\"1\"}\n"), []byte("{\"id\":\n")}))
+	server.SetHour(hour(5), []byte("not gzip"))
+	dir := t.TempDir()
+	_, err := mirror(ctx, src, &mirrorOptions{
+		layout:      gharchive.DateLayout{},
+		start:       start,
+		end:         end,
+		dest:        dir,
+		concurrency: 2,
+	})
+	require.NoError(t, err)
+	server.RemoveHour(hour(6))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name(2)), []byte("corrupt"), 0o600))
+	require.NoError(t, os.Remove(filepath.Join(dir, name(3))))
+	zstdName, ok := gharchive.ZstdName(name(7))
+	require.True(t, ok)
+	_, _, err = recompressFile(filepath.Join(dir, name(7)), filepath.Join(dir, zstdName), zstd.SpeedDefault, defaultFrameSize)
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(filepath.Join(dir, name(7))))
+
+	checks, err := verifyHours(ctx, src, gharchive.NewDirSource(dir), gharchive.DateLayout{}, start, end, 2)
+	require.NoError(t, err)
+	require.Len(t, checks, 8)
+	require.Equal(t, hourCheck{Name: name(0)}, checks[0])
+	require.Equal(t, hourCheck{Name: name(1), Missing: true}, checks[1])
+	require.Contains(t, checks[2].Problem, "read 7 bytes")
+	require.Equal(t, "not mirrored", checks[3].Problem)
+	require.Equal(t, "invalid json on line 2", checks[4].Problem)
+	require.Contains(t, checks[5].Problem, "invalid gzip")
+	require.Equal(t, "not in bucket", checks[6].Problem)
+	require.Equal(t, hourCheck{Name: name(7)}, checks[7])
+
+	var buf bytes.Buffer
+	problems, err := writeHourChecks(&buf, checks)
+	require.NoError(t, err)
+	require.Equal(t, 5, problems)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 10)
+	require.Equal(t, "8 hours, 2 ok, 1 missing, 5 problems", lines[9])
+}
@@ -1,28 +1,33 @@
 package gharchive
 
 import (
-	"bytes"
 	"context"
 	"io"
+	"log/slog"
 	"sync"
 	"time"
 
 	"github.com/killa-beez/gopkgs/pool"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type concurrentScanner struct {
 	scanners    []*singleScanner
 	scannerErrs []error
-	lines       chan []byte
+	batches     chan *lineBatch
+	budget      *byteBudget
+	pending     *pendingHours // nil unless lines are deduped
 	cancel      func()
-	bytes       []byte
+	batch       *lineBatch
+	iterator    batchIterator
+	metrics     Metrics
+	logger      *slog.Logger
+	stats       *validatorStats
 
 	errLock sync.RWMutex
 	err     error
 
-	doneLock sync.Mutex
-	doneChan chan struct{}
-	done     bool
+	doneChan chan struct{} // closed when every worker has stopped
 }
 
 func newConcurrentScanner(ctx context.Context, startTime time.Time, opts *Options) (*concurrentScanner, error) {
@@ -35,21 +40,37 @@ func newConcurrentScanner(ctx context.Context, startTime time.Time, opts *Option
 	}
 	opts.SingleHour = true
 	startTime = startTime.UTC()
-	hour := time.Date(startTime.Year(), startTime.Month(), startTime.Day(), startTime.Hour(), 0, 0, 0, time.UTC)
+	period := opts.Layout().Period()
+	hour := startTime.Truncate(period)
 	var scanners []*singleScanner
+	progress := newProgressReporter(opts.Progress, hourCount(startTime, endTime, period))
+	stats := newValidatorStats(len(opts.Validators))
+	corruptions := newCorruptionReporter(opts.ReportCorruption, opts.logger())
 	for hour.Before(endTime) {
 		scanner, err := newSingleScanner(ctx, hour, opts)
 		if err != nil {
 			return nil, err
 		}
+		scanner.rangeStart = startTime
+		scanner.rangeEnd = endTime.UTC()
+		scanner.progress = progress
+		scanner.stats = stats
+		scanner.corruptions = corruptions
 		scanners = append(scanners, scanner)
-		hour = hour.Add(time.Hour)
+		hour = hour.Add(period)
 	}
 	m := &concurrentScanner{
 		scanners:    scanners,
 		scannerErrs: make([]error, len(scanners)),
-		lines:       make(chan []byte, opts.Concurrency*100_000),
+		batches:     make(chan *lineBatch, opts.Concurrency*100_000/batchMaxLines),
+		budget:      newByteBudget(opts.MaxBufferedBytes),
 		doneChan:    make(chan struct{}),
+		metrics:     opts.Metrics,
+		logger:      opts.logger(),
+		stats:       stats,
+	}
+	if opts.Dedupe != nil {
+		m.pending = newPendingHours(startTime.Truncate(period), len(scanners), period)
 	}
 	ctx, m.cancel = context.WithCancel(ctx)
 
@@ -58,7 +79,7 @@ func newConcurrentScanner(ctx context.Context, startTime time.Time, opts *Option
 		i := i
 		scanner := scanners[i]
 		p.Add(pool.NewWorkUnit(func(ctx2 context.Context) {
-			scannerErr := runScanner(ctx2, scanner, m.lines)
+			scannerErr := runScanner(ctx2, scanner, m.batches, m.budget, m.pending)
 			if scannerErr == io.EOF {
 				scannerErr = nil
 			}
@@ -68,46 +89,67 @@ func newConcurrentScanner(ctx context.Context, startTime time.Time, opts *Option
 	p.Start(ctx)
 	go func() {
 		p.Wait()
-		m.beDone()
+		close(m.doneChan)
 	}()
 	return m, nil
 }
 
-func (m *concurrentScanner) beDone() {
-	m.doneLock.Lock()
-	defer m.doneLock.Unlock()
-	if m.done {
-		return
-	}
-	close(m.doneChan)
-	m.done = true
-}
-
-var bufPool sync.Pool
-
-func runScanner(ctx context.Context, scanner *singleScanner, lines chan<- []byte) error {
-	buf, ok := bufPool.Get().(*bytes.Buffer)
-	if !ok {
-		buf = bytes.NewBuffer(make([]byte, 0, 8192))
-	}
-	defer bufPool.Put(buf)
-	for scanner.Scan(ctx) {
-		buf.Reset()
-		_, err := buf.ReadFrom(bytes.NewReader(scanner.Bytes()))
+// runScanner sends the lines from scanner to batches. Each batch is owned by the receiver once it is sent.
+// Sending waits for room in budget. Batches give their bytes back to budget when they are released. The scanner's
+// hour stays pending until runScanner returns and every batch from it is released.
+func runScanner(ctx context.Context, scanner *singleScanner, batches chan<- *lineBatch, budget *byteBudget, pending *pendingHours) (err error) {
+	ctx, span := scanner.tracer.Start(ctx, "gharchive.worker", trace.WithAttributes(hourAttr(scanner.startTime)))
+	scanner.logger.Debug("worker started", "hour", scanner.startTime)
+	var lines int64
+	defer func() {
+		pending.add(scanner.startTime, -1)
+		endSpan(span, err, attrLines.Int64(lines))
+		scanner.logger.Debug("worker stopped", "hour", scanner.startTime, "lines", lines, "error", err)
+	}()
+	send := func(batch *lineBatch) error {
+		err := batch.charge(ctx, budget)
 		if err != nil {
+			batch.release()
 			return err
 		}
 		select {
 		case <-ctx.Done():
+			batch.release()
 			return ctx.Err()
-		case lines <- buf.Bytes():
+		case batches <- batch:
+			return nil
+		}
+	}
+	batch := newLineBatch()
+	batch.track(pending, scanner.startTime)
+	for scanner.Scan(ctx) {
+		line := scanner.Bytes()
+		if batch.full(len(line)) {
+			err := send(batch)
+			if err != nil {
+				return err
+			}
+			batch = newLineBatch()
+			batch.track(pending, scanner.startTime)
 		}
+		batch.add(line)
+		lines++
+	}
+	if len(batch.lines) == 0 {
+		batch.release()
+		return scanner.Err()
+	}
+	err = send(batch)
+	if err != nil {
+		return err
 	}
 	return scanner.Err()
 }
 
 func (m *concurrentScanner) Close() error {
 	m.cancel()
+	// wait for the workers to stop so no scanner is closed while it is being read
+	<-m.doneChan
 	var err error
 	for _, scanner := range m.scanners {
 		closeErr := scanner.Close()
@@ -115,7 +157,6 @@ func (m *concurrentScanner) Close() error {
 			err = closeErr
 		}
 	}
-	m.beDone()
 	return err
 }
 
@@ -126,29 +167,78 @@ func (m *concurrentScanner) Err() error {
 	return err
 }
 
-func (m *concurrentScanner) Scan(_ context.Context) bool {
+func (m *concurrentScanner) Scan(ctx context.Context) bool {
+	return m.iterator.scan(ctx, m.NextBatch)
+}
+
+// NextBatch returns the next batch of lines from any worker. The lines are valid until the next call to NextBatch.
+func (m *concurrentScanner) NextBatch(ctx context.Context) [][]byte {
+	if m.batch != nil {
+		// the consumer is done with every line in this batch, so it can be reused
+		m.batch.release()
+		m.batch = nil
+	}
+	m.batch = m.takeBatch(ctx)
+	if m.batch == nil {
+		return nil
+	}
+	return m.batch.lines
+}
+
+// takeBatch waits for the next batch from any worker and hands ownership of it to the caller. It returns nil when
+// all workers are done or ctx is canceled.
+func (m *concurrentScanner) takeBatch(ctx context.Context) *lineBatch {
+	if m.metrics != nil {
+		defer func() {
+			m.metrics.QueueDepth(len(m.batches))
+		}()
+	}
 	select {
-	case m.bytes = <-m.lines:
-		return true
+	case batch := <-m.batches:
+		return batch
 	default:
 	}
 
 	select {
-	case m.bytes = <-m.lines:
-		return true
-	case <-m.doneChan:
+	case batch := <-m.batches:
+		return batch
+	case <-ctx.Done():
+		m.logger.Info("scan canceled while waiting for lines", "error", ctx.Err())
 		m.errLock.Lock()
-		for _, err := range m.scannerErrs {
-			if err != nil {
-				m.err = err
-				break
-			}
-		}
+		m.err = ctx.Err()
 		m.errLock.Unlock()
-		return false
+		return nil
+	case <-m.doneChan:
 	}
+
+	// workers are done sending, but batches may still be buffered
+	select {
+	case batch := <-m.batches:
+		return batch
+	default:
+	}
+
+	m.errLock.Lock()
+	for _, err := range m.scannerErrs {
+		if err != nil {
+			m.err = err
+			break
+		}
+	}
+	m.errLock.Unlock()
+	return nil
+}
+
+// ValidatorStats returns counts for each of opts.Validators from all workers.
+func (m *concurrentScanner) ValidatorStats() []ValidatorStats {
+	return m.stats.snapshot()
+}
+
+// BufferedBytes returns the number of bytes in batches that have been sent by workers but not released by the consumer.
+func (m *concurrentScanner) BufferedBytes() int64 {
+	return m.budget.usage()
 }
 
 func (m *concurrentScanner) Bytes() []byte {
-	return m.bytes
+	return m.iterator.bytes()
 }
@@ -28,7 +28,7 @@ func Test_concurrentScanner(t *testing.T) {
 			count++
 		}
 		require.NoError(t, scanner.Err())
-		require.Equal(t, 33, count)
+		require.Equal(t, 30, count)
 	})
 
 	t.Run("regular", func(t *testing.T) {
@@ -53,6 +53,25 @@ func Test_concurrentScanner(t *testing.T) {
 			count++
 		}
 		require.NoError(t, scanner.Err())
-		require.Equal(t, 280628, count)
+		require.Equal(t, 280625, count)
 	})
 }
+
+func Test_concurrentScanner_closeEarly(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	end := start.Add(8 * time.Hour)
+	src := writeEventHours(t, start, end, 5000)
+	for i := 0; i < 5; i++ {
+		scanner, err := newConcurrentScanner(ctx, start, &Options{
+			Source:      src,
+			EndTime:     end,
+			Concurrency: 4,
+		})
+		require.NoError(t, err)
+		for j := 0; j < 100; j++ {
+			require.True(t, scanner.Scan(ctx))
+		}
+		require.NoError(t, scanner.Close())
+	}
+}
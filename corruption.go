@@ -0,0 +1,92 @@
+package gharchive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+)
+
+// CorruptionPolicy is what a Scanner does when an hour's gzip or zstd data is corrupt or truncated.
+type CorruptionPolicy int
+
+const (
+	// CorruptionFail ends the scan with a *CorruptionError.
+	CorruptionFail CorruptionPolicy = iota
+	// CorruptionSkipHour stops reading the hour and continues with the next one.
+	CorruptionSkipHour
+	// CorruptionResync skips to the next gzip member or zstd frame in the hour and continues reading from there.
+	CorruptionResync
+)
+
+// CorruptionError describes corrupt or truncated compressed data in an hour. With policies other than
+// CorruptionFail, the partial line before the corrupt data is dropped.
+type CorruptionError struct {
+	Hour    time.Time
+	Object  string // the name the object was opened with. a Source may read a variant like ZstdName instead
+	Offset  int64  // approximate offset in the compressed object where the corruption was found
+	Resumed int64  // offset of the gzip member or zstd frame where reading resumed. -1 when reading didn't resume in this hour
+	Err     error
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("corrupt data in %s at offset %d: %v", e.Object, e.Offset, e.Err)
+}
+
+func (e *CorruptionError) Unwrap() error {
+	return e.Err
+}
+
+// errCorruptionSkipped is returned by objReader after it skips corrupt data. lineScanner drops its partial line and
+// keeps reading.
+var errCorruptionSkipped = errors.New("corrupt data skipped")
+
+// isCorruption returns true when err from a gzip.Reader is caused by the compressed data rather than by reading it.
+func isCorruption(err error) bool {
+	var corruptInput flate.CorruptInputError
+	return errors.Is(err, gzip.ErrHeader) ||
+		errors.Is(err, gzip.ErrChecksum) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &corruptInput)
+}
+
+// gzipMagic starts every gzip member that uses deflate.
+var gzipMagic = []byte{0x1f, 0x8b, 8}
+
+// zstdMagic starts every zstd frame.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// corruptionReporter logs corruption and calls an Options.ReportCorruption func one call at a time.
+type corruptionReporter struct {
+	fn     func(*CorruptionError)
+	logger *slog.Logger
+	mux    sync.Mutex
+}
+
+func newCorruptionReporter(fn func(*CorruptionError), logger *slog.Logger) *corruptionReporter {
+	return &corruptionReporter{
+		fn:     fn,
+		logger: logger,
+	}
+}
+
+func (r *corruptionReporter) report(e *CorruptionError) {
+	r.logger.Warn("skipped corrupt data",
+		"hour", e.Hour,
+		"object", e.Object,
+		"offset", e.Offset,
+		"resumed", e.Resumed,
+		"error", e.Err,
+	)
+	if r.fn == nil {
+		return
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.fn(e)
+}
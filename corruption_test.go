@@ -0,0 +1,91 @@
+package gharchive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanner_OnCorruption(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	lines := testEventLines(start, 300)
+	members := make([][]byte, 3)
+	for i := range members {
+		members[i] = gzipLines(t, lines[i*100:(i+1)*100])
+	}
+	badHeader := append([]byte{0}, members[1][1:]...)
+	// the first hour has a member with a bad header and trailing garbage. the second is truncated mid-member.
+	src := NewDirSource(t.TempDir())
+	writeObject(t, src, ObjectName(start), bytes.Join([][]byte{members[0], badHeader, members[2], []byte("trailing garbage")}, nil))
+	writeObject(t, src, ObjectName(start.Add(time.Hour)), bytes.Join([][]byte{members[0], members[2][:len(members[2])/2]}, nil))
+
+	scan := func(policy CorruptionPolicy) ([]string, []*CorruptionError, error) {
+		t.Helper()
+		var corruptions []*CorruptionError
+		scanner, err := New(ctx, start, &Options{
+			Source:           src,
+			EndTime:          start.Add(2 * time.Hour),
+			PreserveOrder:    true,
+			OnCorruption:     policy,
+			ReportCorruption: func(e *CorruptionError) { corruptions = append(corruptions, e) },
+			Validators:       []Validator{ValidateNotEmpty()},
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			require.NoError(t, scanner.Close())
+		})
+		var got []string
+		for scanner.Scan(ctx) {
+			got = append(got, string(scanner.Bytes()))
+		}
+		return got, corruptions, scanner.Err()
+	}
+
+	t.Run("fail", func(t *testing.T) {
+		got, corruptions, err := scan(CorruptionFail)
+		var corruptionErr *CorruptionError
+		require.True(t, errors.As(err, &corruptionErr), "got %v", err)
+		require.Equal(t, start, corruptionErr.Hour)
+		require.Equal(t, ObjectName(start), corruptionErr.Object)
+		require.Equal(t, int64(-1), corruptionErr.Resumed)
+		require.Empty(t, corruptions)
+		require.Equal(t, lineStrings(lines[:100]), got)
+	})
+
+	t.Run("skip hour", func(t *testing.T) {
+		got, corruptions, err := scan(CorruptionSkipHour)
+		require.NoError(t, err)
+		require.Len(t, corruptions, 2)
+		require.Equal(t, start, corruptions[0].Hour)
+		require.GreaterOrEqual(t, corruptions[0].Offset, int64(len(members[0])))
+		require.Equal(t, int64(-1), corruptions[0].Resumed)
+		require.Equal(t, start.Add(time.Hour), corruptions[1].Hour)
+		require.True(t, errors.Is(corruptions[1], io.ErrUnexpectedEOF))
+		// the partial line before the truncation is dropped
+		secondHour := got[100:]
+		require.Equal(t, lineStrings(lines[:100]), got[:100])
+		require.Equal(t, lineStrings(lines[:100]), secondHour[:100])
+		for _, line := range secondHour[100:] {
+			require.Contains(t, lineStrings(lines[200:]), line)
+		}
+	})
+
+	t.Run("resync", func(t *testing.T) {
+		got, corruptions, err := scan(CorruptionResync)
+		require.NoError(t, err)
+		require.Len(t, corruptions, 3)
+		require.Equal(t, int64(len(members[0])+len(members[1])), corruptions[0].Resumed)
+		require.Equal(t, int64(-1), corruptions[1].Resumed)
+		require.Equal(t, start.Add(time.Hour), corruptions[2].Hour)
+		require.Equal(t, lineStrings(lines[:100], lines[200:]), got[:200])
+		for _, line := range got[300:] {
+			require.Contains(t, lineStrings(lines[200:]), line)
+		}
+	})
+}
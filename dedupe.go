@@ -0,0 +1,243 @@
+package gharchive
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// DedupeOptions configures dropping events with an id that has already been seen.
+//
+// Ids are remembered in a bloom filter for each hour of created_at. Filters for hours more than Window before both the
+// newest created_at seen and the oldest hour that concurrent downloads haven't finished are discarded, so memory use
+// depends on Window and Concurrency and not on how long the scan is. A small fraction of events (about
+// FalsePositiveRate for each remembered hour) may be dropped even though their id wasn't seen before.
+type DedupeOptions struct {
+	Window            time.Duration // how far apart the created_at values of two events with the same id can be and still be caught. default: 2 hours
+	EventsPerHour     int           // the expected number of events per hour. used to size filters. default: 200,000
+	FalsePositiveRate float64       // the false positive rate of each hour's filter. default: 0.0001
+}
+
+func (o *DedupeOptions) withDefaults() *DedupeOptions {
+	out := new(DedupeOptions)
+	if o != nil {
+		*out = *o
+	}
+	if out.Window == 0 {
+		out.Window = 2 * time.Hour
+	}
+	if out.EventsPerHour == 0 {
+		out.EventsPerHour = 200_000
+	}
+	if out.FalsePositiveRate == 0 {
+		out.FalsePositiveRate = 0.0001
+	}
+	return out
+}
+
+// deduper remembers event ids in rotating bloom filters keyed on created_at hour.
+type deduper struct {
+	opts    *DedupeOptions
+	filters map[int64]*bloomFilter
+	newest  int64
+	oldest  time.Time // the oldest hour that may still have lines to come. zero when lines come in order
+	floor   int64     // filters for buckets before floor have been discarded
+}
+
+func newDeduper(opts *DedupeOptions) *deduper {
+	return &deduper{
+		opts:    opts.withDefaults(),
+		filters: map[int64]*bloomFilter{},
+	}
+}
+
+// duplicate returns true when line is an event with an id that has been seen before. Lines without an id are never
+// duplicates.
+func (d *deduper) duplicate(line []byte) bool {
+	id, createdAt := eventIDAndTime(line)
+	if len(id) == 0 {
+		return false
+	}
+	for _, filter := range d.filters {
+		if filter.has(id) {
+			return true
+		}
+	}
+	bucket := d.newest
+	if !createdAt.IsZero() {
+		bucket = createdAt.Truncate(time.Hour).Unix()
+	}
+	if bucket > d.newest {
+		d.newest = bucket
+	}
+	floor := d.newest
+	if !d.oldest.IsZero() && d.oldest.Unix() < floor {
+		floor = d.oldest.Unix()
+	}
+	floor -= int64(d.opts.Window / time.Second)
+	if floor > d.floor {
+		d.floor = floor
+		for b := range d.filters {
+			if b < floor {
+				delete(d.filters, b)
+			}
+		}
+	}
+	if bucket < d.floor {
+		// too old to be remembered
+		return false
+	}
+	filter := d.filters[bucket]
+	if filter == nil {
+		filter = newBloomFilter(d.opts.EventsPerHour, d.opts.FalsePositiveRate)
+		d.filters[bucket] = filter
+	}
+	filter.add(id)
+	return false
+}
+
+// eventIDAndTime reads the id and created_at fields of an event. id is nil and createdAt is zero when they can't be read.
+func eventIDAndTime(line []byte) (id []byte, createdAt time.Time) {
+	iter := jsoniter.ConfigFastest.BorrowIterator(line)
+	defer jsoniter.ConfigFastest.ReturnIterator(iter)
+	var found int
+	iter.ReadObjectCB(func(iter *jsoniter.Iterator, field string) bool {
+		switch field {
+		case "id":
+			found++
+			switch iter.WhatIsNext() {
+			case jsoniter.StringValue:
+				id = append(id, iter.ReadStringAsSlice()...)
+			case jsoniter.NumberValue:
+				id = append(id, iter.ReadNumber()...)
+			default:
+				iter.Skip()
+			}
+		case "created_at":
+			found++
+			if iter.WhatIsNext() != jsoniter.StringValue {
+				iter.Skip()
+				break
+			}
+			tm, err := time.Parse(time.RFC3339, iter.ReadString())
+			if err == nil {
+				createdAt = tm
+			}
+		default:
+			iter.Skip()
+		}
+		return found < 2
+	})
+	return id, createdAt
+}
+
+// dedupingScanner drops events from another scanner when their ids have already been seen.
+type dedupingScanner struct {
+	inner   iface
+	deduper *deduper
+	batch   *lineBatch
+}
+
+func newDedupingScanner(inner iface, opts *DedupeOptions) *dedupingScanner {
+	return &dedupingScanner{
+		inner:   inner,
+		deduper: newDeduper(opts),
+	}
+}
+
+// NextBatch returns the next batch of lines without duplicates. The lines are valid until the next call to NextBatch.
+func (d *dedupingScanner) NextBatch(ctx context.Context) [][]byte {
+	if d.batch != nil {
+		d.batch.release()
+		d.batch = nil
+	}
+	d.batch = d.takeBatch(ctx)
+	if d.batch == nil {
+		return nil
+	}
+	return d.batch.lines
+}
+
+// takeBatch returns the next non-empty batch of lines without duplicates. The caller owns it.
+func (d *dedupingScanner) takeBatch(ctx context.Context) *lineBatch {
+	for {
+		batch := d.inner.takeBatch(ctx)
+		if batch == nil {
+			return nil
+		}
+		d.deduper.oldest, _ = batch.pending.oldest()
+		batch.filter(func(line []byte) bool {
+			return !d.deduper.duplicate(line)
+		})
+		if len(batch.lines) > 0 {
+			return batch
+		}
+		batch.release()
+	}
+}
+
+func (d *dedupingScanner) ValidatorStats() []ValidatorStats {
+	return d.inner.ValidatorStats()
+}
+
+func (d *dedupingScanner) BufferedBytes() int64 {
+	return d.inner.BufferedBytes()
+}
+
+func (d *dedupingScanner) Err() error {
+	return d.inner.Err()
+}
+
+func (d *dedupingScanner) Close() error {
+	return d.inner.Close()
+}
+
+// pendingHours tracks the hours of a concurrent scan that may still have lines to come. An hour is pending until its
+// worker is done and every batch of its lines has been released.
+type pendingHours struct {
+	mux    sync.Mutex
+	start  time.Time
+	period time.Duration
+	counts []int // for each hour from start, 1 for its worker until it's done plus its unreleased batches
+	first  int   // every count before first is 0
+}
+
+func newPendingHours(start time.Time, hours int, period time.Duration) *pendingHours {
+	p := &pendingHours{
+		start:  start,
+		period: period,
+		counts: make([]int, hours),
+	}
+	for i := range p.counts {
+		p.counts[i] = 1
+	}
+	return p
+}
+
+// add adds n to the count for hour.
+func (p *pendingHours) add(hour time.Time, n int) {
+	if p == nil {
+		return
+	}
+	p.mux.Lock()
+	p.counts[hour.Sub(p.start)/p.period] += n
+	p.mux.Unlock()
+}
+
+// oldest returns the oldest pending hour. It returns false when p is nil or no hours are pending.
+func (p *pendingHours) oldest() (time.Time, bool) {
+	if p == nil {
+		return time.Time{}, false
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	for p.first < len(p.counts) && p.counts[p.first] == 0 {
+		p.first++
+	}
+	if p.first == len(p.counts) {
+		return time.Time{}, false
+	}
+	return p.start.Add(time.Duration(p.first) * p.period), true
+}
@@ -0,0 +1,129 @@
+package gharchive
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_bloomFilter(t *testing.T) {
+	filter := newBloomFilter(10_000, 0.001)
+	for i := 0; i < 10_000; i++ {
+		filter.add([]byte(fmt.Sprintf("in-%d", i)))
+	}
+	for i := 0; i < 10_000; i++ {
+		require.True(t, filter.has([]byte(fmt.Sprintf("in-%d", i))))
+	}
+	var falsePositives int
+	for i := 0; i < 10_000; i++ {
+		if filter.has([]byte(fmt.Sprintf("out-%d", i))) {
+			falsePositives++
+		}
+	}
+	require.Less(t, falsePositives, 50)
+}
+
+func Test_deduper(t *testing.T) {
+	event := func(id string, createdAt time.Time) []byte {
+		return []byte(fmt.Sprintf(`{"id":"%s","actor":{"id":1},"created_at":%q}`, id, createdAt.Format(time.RFC3339)))
+	}
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	d := newDeduper(&DedupeOptions{
+		Window:        time.Hour,
+		EventsPerHour: 1000,
+	})
+	require.False(t, d.duplicate(event("1", start)))
+	require.False(t, d.duplicate(event("2", start.Add(59*time.Minute))))
+	require.True(t, d.duplicate(event("1", start.Add(59*time.Minute))))
+	require.True(t, d.duplicate(event("2", start.Add(61*time.Minute))))
+	require.False(t, d.duplicate([]byte(`{"type":"PushEvent"}`)))
+	require.False(t, d.duplicate([]byte(`{"type":"PushEvent"}`)))
+	require.False(t, d.duplicate([]byte(`{"id":12,"created_at":"garbage"}`)))
+	require.True(t, d.duplicate([]byte(`{"id":12,"created_at":"garbage"}`)))
+
+	// id 1 is forgotten once it's more than the window behind the newest event
+	require.False(t, d.duplicate(event("3", start.Add(3*time.Hour))))
+	require.Len(t, d.filters, 1)
+	require.False(t, d.duplicate(event("1", start.Add(3*time.Hour))))
+}
+
+func Test_deduper_outOfOrderHours(t *testing.T) {
+	event := func(id string, createdAt time.Time) []byte {
+		return []byte(fmt.Sprintf(`{"id":"%s","created_at":%q}`, id, createdAt.Format(time.RFC3339)))
+	}
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	pending := newPendingHours(start, 4, time.Hour)
+	d := newDeduper(&DedupeOptions{
+		Window:        time.Hour,
+		EventsPerHour: 1000,
+	})
+	// batch sets d.oldest the way dedupingScanner does for a batch of lines from hour
+	batch := func(hour time.Time) {
+		pending.add(hour, 1)
+		pending.add(hour, -1)
+		d.oldest, _ = pending.oldest()
+	}
+
+	batch(start)
+	require.False(t, d.duplicate(event("1", start.Add(10*time.Minute))))
+	// hour 11 is read before hour 8 is done
+	batch(start.Add(3 * time.Hour))
+	require.False(t, d.duplicate(event("2", start.Add(3*time.Hour))))
+	batch(start)
+	require.True(t, d.duplicate(event("1", start.Add(20*time.Minute))))
+	require.False(t, d.duplicate(event("3", start.Add(30*time.Minute))))
+
+	// hours 8 and 9 are done, so hour 8 is more than the window before the oldest pending hour
+	pending.add(start, -1)
+	pending.add(start.Add(time.Hour), -1)
+	batch(start.Add(2 * time.Hour))
+	require.False(t, d.duplicate(event("4", start.Add(2*time.Hour))))
+	require.False(t, d.duplicate(event("1", start.Add(2*time.Hour))))
+	require.True(t, d.duplicate(event("2", start.Add(2*time.Hour))))
+}
+
+func Test_pendingHours(t *testing.T) {
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	var nilPending *pendingHours
+	nilPending.add(start, 1)
+	_, ok := nilPending.oldest()
+	require.False(t, ok)
+
+	p := newPendingHours(start, 3, time.Hour)
+	requireOldest := func(want time.Time) {
+		t.Helper()
+		got, ok := p.oldest()
+		require.Equal(t, !want.IsZero(), ok)
+		require.Equal(t, want, got)
+	}
+	requireOldest(start)
+	batch := newLineBatch()
+	batch.track(p, start)
+	p.add(start.Add(time.Hour), -1)
+	p.add(start, -1)
+	requireOldest(start)
+	batch.release()
+	requireOldest(start.Add(2 * time.Hour))
+	p.add(start.Add(2*time.Hour), -1)
+	requireOldest(time.Time{})
+}
+
+func TestScanner_Dedupe(t *testing.T) {
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	first := testEventLines(start, 100)
+	second := testEventLines(start.Add(time.Hour), 100)
+	// the second hour repeats the last 10 events from the first
+	src := NewDirSource(t.TempDir())
+	writeObject(t, src, ObjectName(start), gzipLines(t, first))
+	writeObject(t, src, ObjectName(start.Add(time.Hour)), gzipLines(t, append(append([][]byte{}, first[90:]...), second...)))
+	got := scanLines(t, start, &Options{
+		Source:        src,
+		EndTime:       start.Add(2 * time.Hour),
+		PreserveOrder: true,
+		Validators:    []Validator{ValidateNotEmpty()},
+		Dedupe:        &DedupeOptions{},
+	})
+	require.Equal(t, lineStrings(first, second), got)
+}
@@ -3,36 +3,62 @@ package gharchive
 import (
 	"context"
 	"io"
+	"log/slog"
+	"net/http"
 	"time"
 
 	"cloud.google.com/go/storage"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/api/option"
 )
 
 type iface interface {
 	io.Closer
-	Scan(ctx context.Context) bool
-	Bytes() []byte
+	NextBatch(ctx context.Context) [][]byte
+	takeBatch(ctx context.Context) *lineBatch
+	BufferedBytes() int64
+	ValidatorStats() []ValidatorStats
 	Err() error
 }
 
 // Scanner scans lines from gharchive
 type Scanner struct {
-	scanner iface
+	scanner  iface
+	iterator batchIterator
 }
 
 // Scan advances the scanner to the next token, which will then be available through
 // the Bytes method. It returns false when the scan stops by reaching the end of the output.
 // After Scan returns false, the Err method will return any error that occurred during scanning.
 func (s *Scanner) Scan(ctx context.Context) bool {
-	return s.scanner.Scan(ctx)
+	return s.iterator.scan(ctx, s.scanner.NextBatch)
 }
 
 // Bytes returns the most recent token generated by a call to Scan.
 // The underlying array may point to data that will be overwritten
 // by a subsequent call to Scan.
 func (s *Scanner) Bytes() []byte {
-	return s.scanner.Bytes()
+	return s.iterator.bytes()
+}
+
+// NextBatch returns the next block of lines. It returns nil when the scan stops by reaching the end of the output.
+// After NextBatch returns nil, the Err method will return any error that occurred during scanning.
+// Lines are valid until the next call to NextBatch or Scan. When NextBatch is called after Scan, it
+// first returns the lines from the current block that Scan hasn't reached yet.
+func (s *Scanner) NextBatch(ctx context.Context) [][]byte {
+	return s.iterator.nextBatch(ctx, s.scanner.NextBatch)
+}
+
+// BufferedBytes returns the number of bytes in lines that have been read from gharchive but not yet
+// consumed by Scan or NextBatch. It is always 0 when lines are read by a single process.
+func (s *Scanner) BufferedBytes() int64 {
+	return s.scanner.BufferedBytes()
+}
+
+// ValidatorStats returns counts of the lines evaluated and rejected by each of Options.Validators in the same order.
+// Counts are updated in batches while the scan runs and are complete once the scan ends.
+func (s *Scanner) ValidatorStats() []ValidatorStats {
+	return s.scanner.ValidatorStats()
 }
 
 // Err returns the first non-EOF error that was encountered by the Scanner.
@@ -53,29 +79,69 @@ func New(ctx context.Context, startTime time.Time, opts *Options) (*Scanner, err
 		return nil, err
 	}
 	scanner := new(Scanner)
+	innerOpts := opts
+	validate := opts.ValidationConcurrency > 1 && len(opts.Validators) > 0
+	if validate {
+		// validators run in their own stage instead of in the scanner
+		innerOpts = new(Options)
+		*innerOpts = *opts
+		innerOpts.Validators = nil
+	}
 	if opts.SingleHour || opts.Concurrency == 1 || opts.PreserveOrder {
-		scanner.scanner, err = newSingleScanner(ctx, startTime, opts)
+		scanner.scanner, err = newSingleScanner(ctx, startTime, innerOpts)
 	} else {
-		scanner.scanner, err = newConcurrentScanner(ctx, startTime, opts)
+		scanner.scanner, err = newConcurrentScanner(ctx, startTime, innerOpts)
 	}
 	if err != nil {
 		return nil, err
 	}
+	if validate {
+		scanner.scanner = newValidatingScanner(ctx, scanner.scanner, opts.Validators, opts.ValidationConcurrency, opts.Metrics)
+	}
+	if opts.Dedupe != nil {
+		scanner.scanner = newDedupingScanner(scanner.scanner, opts.Dedupe)
+	}
 	return scanner, nil
 }
 
-// Validator is a function that returns true when a line passes validation
+// Validator is a function that returns true when a line passes validation. Validators may be called from multiple
+// goroutines at once.
 type Validator func(line []byte) bool
 
 // Options are options for a Scanner
 type Options struct {
-	Validators    []Validator     // list of validators to check each line
-	SingleHour    bool            // ignore end time and just scan the file containing the hour in which start occurs.
-	EndTime       time.Time       // end of the timespan to scan. events up to the second before EndTime will be scanned. ignored when SingleHour is set. default: start time + 1 hour
-	PreserveOrder bool            // run a single process so that the output order is preserved
-	Concurrency   int             // number of concurrent downloads to run. ignored when PreserveOrder is set. default: 1
-	Bucket        string          // the GCP bucket for gharchive. default: data.gharchive.org
-	StorageClient *storage.Client // a client to use instead of the default.
+	Validators            []Validator            // list of validators to check each line
+	SingleHour            bool                   // ignore end time and just scan the file containing the hour in which start occurs.
+	EndTime               time.Time              // end of the timespan to scan. events up to the second before EndTime will be scanned. ignored when SingleHour is set. default: start time + 1 hour
+	StrictTimeRange       bool                   // only scan events with a created_at from the start time up to the second before EndTime (or the end of the start hour when SingleHour is set). reading the last hour stops early once its events are well past the end.
+	NormalizeLegacy       bool                   // map events in the Timeline format used before 2015 to the modern envelope before validators see them. see NormalizeLegacyEvent.
+	PreserveOrder         bool                   // run a single process so that the output order is preserved
+	Concurrency           int                    // number of concurrent downloads to run. ignored when PreserveOrder is set. default: 1
+	DecodeConcurrency     int                    // when > 1, each object is downloaded, decompressed and split into lines on separate goroutines with read-ahead buffers, and up to DecodeConcurrency zstd frames are decompressed at once. gzip members are decompressed in order because their boundaries aren't known ahead of time. default: one goroutine per object
+	ValidationConcurrency int                    // number of goroutines to run validators on. when > 1, validators run in a separate stage that preserves output order. default: validators run on the download goroutines
+	Dedupe                *DedupeOptions         // when set, drop events with an id that has already been seen. default: no deduplication
+	OnCorruption          CorruptionPolicy       // what to do when an hour's compressed data is corrupt or truncated. default: CorruptionFail
+	ReportCorruption      func(*CorruptionError) // called for each corruption that OnCorruption skips. calls are never concurrent. default: skipped corruption is only logged
+	Metrics               Metrics                // receives measurements of the scan. default: no metrics
+	TracerProvider        trace.TracerProvider   // provides the tracer for OpenTelemetry spans around hour downloads. default: no tracing
+	Logger                slog.Handler           // receives logs about hours, workers, retries and cancellation. retries are only logged when StorageClient isn't set. default: no logging
+	Progress              func(Progress)         // called after each hour is read. calls are never concurrent. when ValidationConcurrency > 1, lines are counted before validators run. default: no progress reporting
+	MaxBufferedBytes      int64                  // max bytes of lines buffered ahead of the consumer by all concurrent downloads. ignored when PreserveOrder or SingleHour is set. default: no limit
+	Bucket                string                 // the GCP bucket for gharchive. default: data.gharchive.org
+	ObjectLayout          ObjectLayout           // how objects in Bucket are named. with layouts that cover more than an hour, "hour" in other options and in Progress means an object's period. default: DateLayout{}, the layout of data.gharchive.org
+	StorageClient         *storage.Client        // a client to use instead of the default.
+	Source                Source                 // where to read objects from. Bucket and StorageClient are ignored when it is set. default: a GCSSource for Bucket
+	IndexFilter           map[string][]string    // values of IndexFields to look for. hours are skipped when their index shows that no event has any of the values for one of the fields. lines aren't filtered, so pair it with a ValidateFieldValues validator for the same values. default: no hours are skipped
+	IndexSource           Source                 // where to read index files named by IndexName and SeekIndexName from. hours without an index file are read in full. default: Source
+	SeekIndex             bool                   // with StrictTimeRange, start reading the hour containing the start time from the checkpoint in its seek index file that is closest to the start time. ignored unless Source is a RangeSource. default: hours are read from the start
+}
+
+// Layout returns o.ObjectLayout or the layout of data.gharchive.org when it isn't set. o may be nil.
+func (o *Options) Layout() ObjectLayout {
+	if o == nil || o.ObjectLayout == nil {
+		return defaultLayout
+	}
+	return o.ObjectLayout
 }
 
 func (o *Options) withDefaults(ctx context.Context) (*Options, error) {
@@ -88,8 +154,18 @@ func (o *Options) withDefaults(ctx context.Context) (*Options, error) {
 	out := new(Options)
 	*out = *o
 	var err error
-	if out.StorageClient == nil {
-		out.StorageClient, err = storage.NewClient(ctx, option.WithoutAuthentication())
+	if out.StorageClient == nil && out.Source == nil {
+		clientOpt := option.WithoutAuthentication()
+		if out.Metrics != nil || out.Logger != nil {
+			clientOpt = option.WithHTTPClient(&http.Client{
+				Transport: &retryTransport{
+					base:    http.DefaultTransport,
+					metrics: out.Metrics,
+					logger:  out.logger(),
+				},
+			})
+		}
+		out.StorageClient, err = storage.NewClient(ctx, clientOpt)
 		if err != nil {
 			return nil, err
 		}
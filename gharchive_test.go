@@ -3,6 +3,7 @@ package gharchive
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -10,6 +11,7 @@ import (
 	"path"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/klauspost/compress/gzip"
@@ -130,3 +132,87 @@ func setupShortTestServer(ctx context.Context, t *testing.T) *storage.Client {
 	})
 	return client
 }
+
+// gzipString returns data compressed with gzip.
+func gzipString(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+// testEventTypes are the event types testEventLines cycles through.
+var testEventTypes = []string{"PushEvent", "CreateEvent", "WatchEvent", "IssuesEvent", "PullRequestEvent", "ForkEvent"}
+
+// testEventLines returns count deterministic event lines for hour. Each line ends in a newline. Events cycle through
+// testEventTypes, 5 actors and 7 repos that are unique to the hour. created_at is spread evenly across the hour.
+func testEventLines(hour time.Time, count int) [][]byte {
+	lines := make([][]byte, count)
+	for i := range lines {
+		createdAt := hour.Add(time.Duration(i) * time.Hour / time.Duration(count))
+		lines[i] = []byte(fmt.Sprintf(
+			`{"id":"%d%05d","type":%q,"actor":{"login":"actor%d"},"repo":{"name":"org%d/repo-%s-%d"},"payload":{"size":%d},"created_at":%q}`+"\n",
+			hour.Unix(), i, testEventTypes[i%len(testEventTypes)], i%5, i%3, hour.Format("2006010215"), i%7, i,
+			createdAt.Format(time.RFC3339),
+		))
+	}
+	return lines
+}
+
+// lineStrings returns lines as strings.
+func lineStrings(lines ...[][]byte) []string {
+	var s []string
+	for _, ll := range lines {
+		for _, line := range ll {
+			s = append(s, string(line))
+		}
+	}
+	return s
+}
+
+// gzipLines returns lines concatenated and gzipped.
+func gzipLines(t testing.TB, lines [][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	for _, line := range lines {
+		_, err := gz.Write(line)
+		require.NoError(t, err)
+	}
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+// writeObject writes data to the object name in src.
+func writeObject(t testing.TB, src *DirSource, name string, data []byte) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filepath.Join(src.Dir(), name), data, 0o600))
+}
+
+// writeEventHours writes count testEventLines for each hour from start up to end and returns a DirSource for them.
+func writeEventHours(t testing.TB, start, end time.Time, count int) *DirSource {
+	t.Helper()
+	src := NewDirSource(t.TempDir())
+	for hour := start; hour.Before(end); hour = hour.Add(time.Hour) {
+		writeObject(t, src, ObjectName(hour), gzipLines(t, testEventLines(hour, count)))
+	}
+	return src
+}
+
+// scanLines returns the lines a Scanner returns from start with opts.
+func scanLines(t testing.TB, start time.Time, opts *Options) []string {
+	t.Helper()
+	ctx := context.Background()
+	scanner, err := New(ctx, start, opts)
+	require.NoError(t, err)
+	var got []string
+	for scanner.Scan(ctx) {
+		got = append(got, string(scanner.Bytes()))
+	}
+	require.NoError(t, scanner.Err())
+	require.NoError(t, scanner.Close())
+	return got
+}
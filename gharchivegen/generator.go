@@ -0,0 +1,230 @@
+// Package gharchivegen generates synthetic gharchive hour files for load and fuzz testing.
+package gharchivegen
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/willabides/gharchive-client"
+)
+
+// DefaultTypeWeights is a mix of event types that roughly resembles recent gharchive data.
+var DefaultTypeWeights = map[string]int{
+	"PushEvent":                     50,
+	"CreateEvent":                   13,
+	"WatchEvent":                    8,
+	"PullRequestEvent":              7,
+	"IssueCommentEvent":             5,
+	"DeleteEvent":                   4,
+	"IssuesEvent":                   3,
+	"ForkEvent":                     2,
+	"PullRequestReviewEvent":        2,
+	"PullRequestReviewCommentEvent": 2,
+	"ReleaseEvent":                  1,
+	"GollumEvent":                   1,
+	"MemberEvent":                   1,
+	"PublicEvent":                   1,
+	"CommitCommentEvent":            1,
+}
+
+// Options are options for a Generator
+type Options struct {
+	EventsPerHour int            // number of events in each hour. default: 10000
+	TypeWeights   map[string]int // relative weight of each event type. default: DefaultTypeWeights
+	Repos         int            // number of distinct repos. default: 10000
+	Actors        int            // number of distinct actors. default: 10000
+	Orgs          int            // number of distinct orgs. Half of repos belong to an org when this is set. default: 0
+	PayloadSize   int            // approximate size in bytes of each event's payload. default: 200
+	Seed          int64          // seed for the random source. The same seed and options always generate the same output.
+}
+
+func (o *Options) withDefaults() *Options {
+	out := new(Options)
+	if o != nil {
+		*out = *o
+	}
+	if out.EventsPerHour == 0 {
+		out.EventsPerHour = 10_000
+	}
+	if len(out.TypeWeights) == 0 {
+		out.TypeWeights = DefaultTypeWeights
+	}
+	if out.Repos == 0 {
+		out.Repos = 10_000
+	}
+	if out.Actors == 0 {
+		out.Actors = 10_000
+	}
+	if out.PayloadSize == 0 {
+		out.PayloadSize = 200
+	}
+	return out
+}
+
+// Generator generates synthetic gharchive events
+type Generator struct {
+	opts        *Options
+	types       []string
+	cumWeights  []int
+	totalWeight int
+}
+
+// New returns a new Generator. It returns an error when a count in opts is negative.
+func New(opts *Options) (*Generator, error) {
+	opts = opts.withDefaults()
+	switch {
+	case opts.EventsPerHour < 1:
+		return nil, errors.New("EventsPerHour must be at least 1")
+	case opts.Repos < 1:
+		return nil, errors.New("Repos must be at least 1")
+	case opts.Actors < 1:
+		return nil, errors.New("Actors must be at least 1")
+	case opts.Orgs < 0:
+		return nil, errors.New("Orgs must not be negative")
+	case opts.PayloadSize < 0:
+		return nil, errors.New("PayloadSize must not be negative")
+	}
+	g := &Generator{
+		opts: opts,
+	}
+	for tp := range opts.TypeWeights {
+		g.types = append(g.types, tp)
+	}
+	// sort so that output doesn't depend on map iteration order
+	sort.Strings(g.types)
+	for _, tp := range g.types {
+		weight := opts.TypeWeights[tp]
+		if weight < 0 {
+			weight = 0
+		}
+		g.totalWeight += weight
+		g.cumWeights = append(g.cumWeights, g.totalWeight)
+	}
+	return g, nil
+}
+
+// HourLines returns the events for the hour containing tm. Each line ends in a newline.
+func (g *Generator) HourLines(tm time.Time) [][]byte {
+	hour := tm.UTC().Truncate(time.Hour)
+	rnd := rand.New(rand.NewSource(g.opts.Seed ^ hour.Unix())) //nolint:gosec // not for security
+	n := g.opts.EventsPerHour
+	offsets := make([]int64, n)
+	for i := range offsets {
+		offsets[i] = rnd.Int63n(int64(time.Hour / time.Second))
+	}
+	sort.Slice(offsets, func(i, j int) bool {
+		return offsets[i] < offsets[j]
+	})
+	firstID := hour.Unix() / 3600 * 10_000_000
+	lines := make([][]byte, n)
+	for i := range lines {
+		createdAt := hour.Add(time.Duration(offsets[i]) * time.Second)
+		lines[i] = g.event(rnd, firstID+int64(i), createdAt)
+	}
+	return lines
+}
+
+// WriteHour writes the gzipped file for the hour containing tm to w.
+func (g *Generator) WriteHour(w io.Writer, tm time.Time) error {
+	gzw := gzip.NewWriter(w)
+	for _, line := range g.HourLines(tm) {
+		_, err := gzw.Write(line)
+		if err != nil {
+			return err
+		}
+	}
+	return gzw.Close()
+}
+
+// WriteDir writes a file for every hour from start up to the hour before end to dir. Files are named with gharchive.ObjectName.
+func (g *Generator) WriteDir(dir string, start, end time.Time) error {
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return err
+	}
+	for hour := start.UTC().Truncate(time.Hour); hour.Before(end); hour = hour.Add(time.Hour) {
+		err = g.writeFile(filepath.Join(dir, gharchive.ObjectName(hour)), hour)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Generator) writeFile(filename string, hour time.Time) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(file)
+	err = g.WriteHour(bw, hour)
+	if err == nil {
+		err = bw.Flush()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (g *Generator) eventType(rnd *rand.Rand) string {
+	if g.totalWeight == 0 {
+		return g.types[rnd.Intn(len(g.types))]
+	}
+	n := rnd.Intn(g.totalWeight)
+	idx := sort.SearchInts(g.cumWeights, n+1)
+	return g.types[idx]
+}
+
+const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+func (g *Generator) event(rnd *rand.Rand, id int64, createdAt time.Time) []byte {
+	actorID := rnd.Intn(g.opts.Actors)
+	repoID := rnd.Intn(g.opts.Repos)
+	orgID := -1
+	owner := "user" + strconv.Itoa(repoID%g.opts.Actors)
+	if g.opts.Orgs > 0 && repoID%2 == 0 {
+		orgID = (repoID / 2) % g.opts.Orgs
+		owner = "org" + strconv.Itoa(orgID)
+	}
+	b := make([]byte, 0, 300+g.opts.PayloadSize)
+	b = append(b, `{"id":"`...)
+	b = strconv.AppendInt(b, id, 10)
+	b = append(b, `","type":"`...)
+	b = append(b, g.eventType(rnd)...)
+	b = append(b, `","actor":{"id":`...)
+	b = strconv.AppendInt(b, int64(actorID), 10)
+	b = append(b, `,"login":"user`...)
+	b = strconv.AppendInt(b, int64(actorID), 10)
+	b = append(b, `"},"repo":{"id":`...)
+	b = strconv.AppendInt(b, int64(repoID), 10)
+	b = append(b, `,"name":"`...)
+	b = append(b, owner...)
+	b = append(b, `/repo`...)
+	b = strconv.AppendInt(b, int64(repoID), 10)
+	b = append(b, `"},"payload":{"body":"`...)
+	for i := 0; i < g.opts.PayloadSize; i++ {
+		b = append(b, letters[rnd.Intn(len(letters))])
+	}
+	b = append(b, `"},"public":true,"created_at":"`...)
+	b = createdAt.AppendFormat(b, time.RFC3339)
+	b = append(b, '"')
+	if orgID >= 0 {
+		b = append(b, `,"org":{"id":`...)
+		b = strconv.AppendInt(b, int64(orgID), 10)
+		b = append(b, `,"login":"`...)
+		b = append(b, owner...)
+		b = append(b, `"}`...)
+	}
+	b = append(b, "}\n"...)
+	return b
+}
@@ -0,0 +1,115 @@
+package gharchivegen_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/willabides/gharchive-client"
+	"github.com/willabides/gharchive-client/gharchivegen"
+	"github.com/willabides/gharchive-client/gharchivetest"
+)
+
+func TestGenerator_HourLines(t *testing.T) {
+	hour := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	opts := &gharchivegen.Options{
+		EventsPerHour: 1000,
+		TypeWeights: map[string]int{
+			"PushEvent":  3,
+			"WatchEvent": 1,
+		},
+		Repos:       20,
+		Actors:      30,
+		Orgs:        5,
+		PayloadSize: 50,
+		Seed:        1,
+	}
+	gen, err := gharchivegen.New(opts)
+	require.NoError(t, err)
+	lines := gen.HourLines(hour)
+	require.Len(t, lines, 1000)
+	require.Equal(t, lines, gen.HourLines(hour.Add(time.Minute)))
+
+	types := map[string]int{}
+	repos := map[string]bool{}
+	actors := map[string]bool{}
+	var lastCreatedAt time.Time
+	for _, line := range lines {
+		require.True(t, bytes.HasSuffix(line, []byte("\n")))
+		var event struct {
+			Type  string `json:"type"`
+			Actor struct {
+				Login string `json:"login"`
+			} `json:"actor"`
+			Repo struct {
+				Name string `json:"name"`
+			} `json:"repo"`
+			CreatedAt time.Time `json:"created_at"`
+		}
+		require.NoError(t, json.Unmarshal(line, &event))
+		types[event.Type]++
+		repos[event.Repo.Name] = true
+		actors[event.Actor.Login] = true
+		require.False(t, event.CreatedAt.Before(lastCreatedAt))
+		require.Equal(t, hour, event.CreatedAt.Truncate(time.Hour))
+		lastCreatedAt = event.CreatedAt
+	}
+	require.Len(t, types, 2)
+	require.InDelta(t, 750, types["PushEvent"], 60)
+	require.LessOrEqual(t, len(repos), 20)
+	require.LessOrEqual(t, len(actors), 30)
+
+	opts.Seed = 2
+	gen, err = gharchivegen.New(opts)
+	require.NoError(t, err)
+	require.NotEqual(t, lines, gen.HourLines(hour))
+}
+
+func TestNew(t *testing.T) {
+	for _, opts := range []*gharchivegen.Options{
+		{EventsPerHour: -1},
+		{Repos: -1},
+		{Actors: -1},
+		{Orgs: -1},
+		{PayloadSize: -1},
+	} {
+		_, err := gharchivegen.New(opts)
+		require.Error(t, err, "%+v", opts)
+	}
+	_, err := gharchivegen.New(nil)
+	require.NoError(t, err)
+}
+
+func TestGenerator_WriteDir(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	start := time.Date(2020, 10, 10, 22, 0, 0, 0, time.UTC)
+	end := start.Add(3 * time.Hour)
+	gen, err := gharchivegen.New(&gharchivegen.Options{
+		EventsPerHour: 100,
+	})
+	require.NoError(t, err)
+	require.NoError(t, gen.WriteDir(dir, start, end))
+
+	server := gharchivetest.NewServer(nil)
+	t.Cleanup(server.Close)
+	require.NoError(t, server.LoadDir(dir))
+	client, err := server.Client(ctx)
+	require.NoError(t, err)
+	scanner, err := gharchive.New(ctx, start, &gharchive.Options{
+		StorageClient: client,
+		EndTime:       end,
+		PreserveOrder: true,
+		Validators:    []gharchive.Validator{gharchive.ValidateNotEmpty()},
+	})
+	require.NoError(t, err)
+	var count int
+	for scanner.Scan(ctx) {
+		count++
+	}
+	require.NoError(t, scanner.Err())
+	require.Equal(t, 300, count)
+}
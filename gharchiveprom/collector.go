@@ -0,0 +1,141 @@
+// Package gharchiveprom exports gharchive.Metrics to Prometheus.
+package gharchiveprom
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/willabides/gharchive-client"
+)
+
+// Options are options for a Collector.
+type Options struct {
+	Namespace      string   // prefix for metric names. default: gharchive
+	ValidatorNames []string // names to use for the validator label. validators without a name are labeled with their index in gharchive.Options.Validators.
+}
+
+// Collector is a prometheus.Collector and a gharchive.Metrics. Use it as gharchive.Options.Metrics and register it
+// with a prometheus.Registerer.
+type Collector struct {
+	validatorNames  []string
+	hourLatency     prometheus.Histogram
+	bytesDownloaded prometheus.Counter
+	bytesRead       prometheus.Counter
+	linesScanned    prometheus.Counter
+	linesRejected   *prometheus.CounterVec
+	retries         prometheus.Counter
+	queueDepth      prometheus.Gauge
+}
+
+var (
+	_ prometheus.Collector = new(Collector)
+	_ gharchive.Metrics    = new(Collector)
+)
+
+// NewCollector returns a new Collector.
+func NewCollector(opts *Options) *Collector {
+	if opts == nil {
+		opts = new(Options)
+	}
+	ns := opts.Namespace
+	if ns == "" {
+		ns = "gharchive"
+	}
+	return &Collector{
+		validatorNames: opts.ValidatorNames,
+		hourLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "hour_download_seconds",
+			Help:      "Time from requesting an hour file to reading the end of it.",
+			Buckets:   prometheus.ExponentialBuckets(0.5, 2, 10),
+		}),
+		bytesDownloaded: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "downloaded_bytes_total",
+			Help:      "Compressed bytes downloaded from completed hour files.",
+		}),
+		bytesRead: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "read_bytes_total",
+			Help:      "Decompressed bytes read from completed hour files.",
+		}),
+		linesScanned: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "scanned_lines_total",
+			Help:      "Lines read from hour files.",
+		}),
+		linesRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "rejected_lines_total",
+			Help:      "Lines rejected by each validator.",
+		}, []string{"validator"}),
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "retries_total",
+			Help:      "Storage responses with a status that is retried.",
+		}),
+		queueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "queue_depth_batches",
+			Help:      "Batches of lines waiting for the consumer of a concurrent scan.",
+		}),
+	}
+}
+
+func (c *Collector) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		c.hourLatency,
+		c.bytesDownloaded,
+		c.bytesRead,
+		c.linesScanned,
+		c.linesRejected,
+		c.retries,
+		c.queueDepth,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range c.collectors() {
+		collector.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, collector := range c.collectors() {
+		collector.Collect(ch)
+	}
+}
+
+// HourRead implements gharchive.Metrics.
+func (c *Collector) HourRead(latency time.Duration, downloadedBytes, decompressedBytes int64) {
+	c.hourLatency.Observe(latency.Seconds())
+	c.bytesDownloaded.Add(float64(downloadedBytes))
+	c.bytesRead.Add(float64(decompressedBytes))
+}
+
+// LinesScanned implements gharchive.Metrics.
+func (c *Collector) LinesScanned(n int64) {
+	c.linesScanned.Add(float64(n))
+}
+
+// LinesRejected implements gharchive.Metrics.
+func (c *Collector) LinesRejected(validator int, n int64) {
+	name := strconv.Itoa(validator)
+	if validator < len(c.validatorNames) && c.validatorNames[validator] != "" {
+		name = c.validatorNames[validator]
+	}
+	c.linesRejected.WithLabelValues(name).Add(float64(n))
+}
+
+// Retried implements gharchive.Metrics.
+func (c *Collector) Retried() {
+	c.retries.Inc()
+}
+
+// QueueDepth implements gharchive.Metrics.
+func (c *Collector) QueueDepth(batches int) {
+	c.queueDepth.Set(float64(batches))
+}
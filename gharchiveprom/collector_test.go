@@ -0,0 +1,84 @@
+package gharchiveprom_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"github.com/willabides/gharchive-client"
+	"github.com/willabides/gharchive-client/gharchiveprom"
+	"github.com/willabides/gharchive-client/gharchivetest"
+)
+
+// gatherValues returns the value of each counter and gauge and the sample count of each histogram keyed on name and
+// label values.
+func gatherValues(t *testing.T, registry *prometheus.Registry) map[string]float64 {
+	t.Helper()
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	values := map[string]float64{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			key := family.GetName()
+			for _, label := range metric.GetLabel() {
+				key += " " + label.GetValue()
+			}
+			switch {
+			case metric.Counter != nil:
+				values[key] = metric.GetCounter().GetValue()
+			case metric.Gauge != nil:
+				values[key] = metric.GetGauge().GetValue()
+			case metric.Histogram != nil:
+				values[key] = float64(metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return values
+}
+
+func TestCollector(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	server := gharchivetest.NewServer(nil)
+	t.Cleanup(server.Close)
+	for hour := start; hour.Before(start.Add(2 * time.Hour)); hour = hour.Add(time.Hour) {
+		require.NoError(t, server.SetHourLines(hour, gharchivetest.SyntheticLines(hour, 100)))
+	}
+	for _, validationConcurrency := range []int{0, 3} {
+		collector := gharchiveprom.NewCollector(&gharchiveprom.Options{
+			ValidatorNames: []string{"not-empty"},
+		})
+		registry := prometheus.NewRegistry()
+		require.NoError(t, registry.Register(collector))
+		client, err := server.Client(ctx)
+		require.NoError(t, err)
+		scanner, err := gharchive.New(ctx, start, &gharchive.Options{
+			StorageClient: client,
+			EndTime:       start.Add(2 * time.Hour),
+			Concurrency:   2,
+			Validators: []gharchive.Validator{
+				gharchive.ValidateNotEmpty(),
+				func(line []byte) bool {
+					return false
+				},
+			},
+			ValidationConcurrency: validationConcurrency,
+			Metrics:               collector,
+		})
+		require.NoError(t, err)
+		for scanner.Scan(ctx) {
+		}
+		require.NoError(t, scanner.Err())
+		require.NoError(t, scanner.Close())
+		values := gatherValues(t, registry)
+		require.Equal(t, float64(2), values["gharchive_hour_download_seconds"])
+		require.Equal(t, float64(200), values["gharchive_scanned_lines_total"])
+		require.Equal(t, float64(0), values["gharchive_rejected_lines_total not-empty"])
+		require.Equal(t, float64(200), values["gharchive_rejected_lines_total 1"])
+		require.Greater(t, values["gharchive_downloaded_bytes_total"], float64(0))
+		require.Greater(t, values["gharchive_read_bytes_total"], values["gharchive_downloaded_bytes_total"])
+		require.Equal(t, float64(0), values["gharchive_queue_depth_batches"])
+	}
+}
@@ -0,0 +1,65 @@
+package gharchivetest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/willabides/gharchive-client/gharchivegen"
+)
+
+// SyntheticLines returns count gharchive-shaped event lines for hour. Each line ends in a newline. The output
+// is deterministic. It panics when count is negative. Use gharchivegen directly for more control over the generated
+// events.
+func SyntheticLines(hour time.Time, count int) [][]byte {
+	gen, err := gharchivegen.New(&gharchivegen.Options{
+		EventsPerHour: count,
+		Repos:         100,
+		Actors:        100,
+		Orgs:          10,
+		PayloadSize:   20,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return gen.HourLines(hour)
+}
+
+// Gzip returns lines concatenated and gzipped.
+func Gzip(lines [][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	for _, line := range lines {
+		_, err := gzw.Write(line)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := gzw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// LoadDir serves every .json.gz file in dir using the file name as the object name.
+func (s *Server) LoadDir(dir string) error {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".json.gz") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			return err
+		}
+		s.SetObject(info.Name(), data)
+	}
+	return nil
+}
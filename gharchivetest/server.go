@@ -0,0 +1,327 @@
+// Package gharchivetest provides a fake gharchive storage server for testing code that uses gharchive offline.
+package gharchivetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/willabides/gharchive-client"
+	"google.golang.org/api/option"
+)
+
+// DefaultBucket is the bucket served when Options.Bucket is empty.
+const DefaultBucket = "data.gharchive.org"
+
+// Fault describes a failure to inject when an hour is requested.
+type Fault struct {
+	StatusCode int           // respond with this http status instead of the object. 404 makes the hour look missing.
+	Count      int           // number of requests to apply StatusCode to before serving normally. 0 means every request.
+	ReadDelay  time.Duration // sleep this long before each chunk of the response body is written.
+	TruncateAt int64         // serve only the first TruncateAt bytes of the object. 0 means don't truncate.
+	BadCRC32C  bool          // report a checksum that doesn't match the content in the object's attributes and in the checksum header of full content responses.
+	DropAfter  int64         // close the connection after DropAfter bytes of a content response's body. the headers still describe the whole response, so clients see an unexpected EOF. 0 means don't drop.
+}
+
+// Options are options for a Server
+type Options struct {
+	Bucket string // the bucket name to serve. default: DefaultBucket
+}
+
+// Server is an in-process server that implements enough of the Google Cloud Storage api to serve
+// gharchive hour files to a *storage.Client.
+type Server struct {
+	URL    string // base url of the server
+	Bucket string // the bucket being served
+
+	httpServer *httptest.Server
+	mux        sync.Mutex
+	objects    map[string]*object
+	faults     map[string]*Fault
+	requests   map[string]int
+}
+
+type object struct {
+	data    []byte
+	updated time.Time
+}
+
+// NewServer starts and returns a new Server. The caller should call Close when finished.
+func NewServer(opts *Options) *Server {
+	if opts == nil {
+		opts = new(Options)
+	}
+	s := &Server{
+		Bucket:   opts.Bucket,
+		objects:  map[string]*object{},
+		faults:   map[string]*Fault{},
+		requests: map[string]int{},
+	}
+	if s.Bucket == "" {
+		s.Bucket = DefaultBucket
+	}
+	s.httpServer = httptest.NewTLSServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.httpServer.URL
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.httpServer.Close()
+}
+
+// Client returns a *storage.Client that talks to this server.
+func (s *Server) Client(ctx context.Context) (*storage.Client, error) {
+	return storage.NewClient(ctx,
+		option.WithoutAuthentication(),
+		option.WithEndpoint(s.URL+"/"),
+		option.WithHTTPClient(s.httpServer.Client()),
+	)
+}
+
+// SetObject sets the raw content of the named object.
+func (s *Server) SetObject(name string, data []byte) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.objects[name] = &object{
+		data:    data,
+		updated: time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// SetHour sets the raw (gzipped) content of the file for hour.
+func (s *Server) SetHour(hour time.Time, data []byte) {
+	s.SetObject(gharchive.ObjectName(hour), data)
+}
+
+// SetHourLines gzips lines and serves them as the file for hour. Lines are written as-is, so they
+// should include their trailing newline.
+func (s *Server) SetHourLines(hour time.Time, lines [][]byte) error {
+	data, err := Gzip(lines)
+	if err != nil {
+		return err
+	}
+	s.SetHour(hour, data)
+	return nil
+}
+
+// RemoveHour makes the hour look missing.
+func (s *Server) RemoveHour(hour time.Time) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.objects, gharchive.ObjectName(hour))
+}
+
+// SetFault injects fault into requests for hour. Pass nil to remove a fault.
+func (s *Server) SetFault(hour time.Time, fault *Fault) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	name := gharchive.ObjectName(hour)
+	if fault == nil {
+		delete(s.faults, name)
+		return
+	}
+	f := *fault
+	s.faults[name] = &f
+}
+
+// Requests returns the number of content requests the server has received for hour.
+func (s *Server) Requests(hour time.Time) int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.requests[gharchive.ObjectName(hour)]
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	p := strings.TrimPrefix(req.URL.Path, "/")
+	attrsPrefix := "b/" + s.Bucket + "/o/"
+	switch {
+	case strings.HasPrefix(p, attrsPrefix):
+		s.serveAttrs(w, strings.TrimPrefix(p, attrsPrefix))
+	case strings.HasPrefix(p, s.Bucket+"/"):
+		s.serveContent(w, req, strings.TrimPrefix(p, s.Bucket+"/"))
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// lookup returns the object and the fault to apply for a content request.
+func (s *Server) lookup(name string, countRequest bool) (*object, *Fault) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	obj := s.objects[name]
+	fault := s.faults[name]
+	if !countRequest {
+		return obj, fault
+	}
+	s.requests[name]++
+	if fault == nil {
+		return obj, nil
+	}
+	f := *fault
+	if f.StatusCode != 0 && f.Count > 0 {
+		if s.requests[name] > f.Count {
+			f.StatusCode = 0
+		}
+	}
+	return obj, &f
+}
+
+func (s *Server) serveAttrs(w http.ResponseWriter, name string) {
+	obj, fault := s.lookup(name, false)
+	if obj == nil || (fault != nil && fault.StatusCode == http.StatusNotFound) {
+		writeJSONError(w, http.StatusNotFound, "No such object: "+s.Bucket+"/"+name)
+		return
+	}
+	data := obj.data
+	if fault != nil && fault.TruncateAt > 0 && fault.TruncateAt < int64(len(data)) {
+		data = data[:fault.TruncateAt]
+	}
+	crc := encodeCRC32C(data)
+	if fault != nil && fault.BadCRC32C {
+		crc = encodeCRC32C(append([]byte("bad"), data...))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	//nolint:errcheck // nothing to do with this error
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"kind":        "storage#object",
+		"name":        name,
+		"bucket":      s.Bucket,
+		"size":        strconv.Itoa(len(data)),
+		"crc32c":      crc,
+		"contentType": "application/gzip",
+		"updated":     obj.updated.Format(time.RFC3339),
+		"timeCreated": obj.updated.Format(time.RFC3339),
+		"generation":  "1",
+	})
+}
+
+func (s *Server) serveContent(w http.ResponseWriter, req *http.Request, name string) {
+	obj, fault := s.lookup(name, true)
+	if fault == nil {
+		fault = new(Fault)
+	}
+	if fault.StatusCode != 0 {
+		http.Error(w, http.StatusText(fault.StatusCode), fault.StatusCode)
+		return
+	}
+	if obj == nil {
+		http.Error(w, "NoSuchKey", http.StatusNotFound)
+		return
+	}
+	data := obj.data
+	if fault.TruncateAt > 0 && fault.TruncateAt < int64(len(data)) {
+		data = data[:fault.TruncateAt]
+	}
+	if req.Header.Get("Range") == "" {
+		crc := encodeCRC32C(data)
+		if fault.BadCRC32C {
+			crc = encodeCRC32C(append([]byte("bad"), data...))
+		}
+		w.Header().Set("X-Goog-Hash", "crc32c="+crc)
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("X-Goog-Generation", "1")
+	var wr http.ResponseWriter = w
+	if fault.DropAfter > 0 {
+		wr = &droppingWriter{
+			ResponseWriter: wr,
+			limit:          fault.DropAfter,
+		}
+	}
+	if fault.ReadDelay > 0 {
+		wr = &slowWriter{
+			ResponseWriter: wr,
+			delay:          fault.ReadDelay,
+		}
+	}
+	http.ServeContent(wr, req, name, obj.updated, bytes.NewReader(data))
+}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+func encodeCRC32C(data []byte) string {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, crc32.Checksum(data, crc32cTable))
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func writeJSONError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	//nolint:errcheck // nothing to do with this error
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": msg,
+		},
+	})
+}
+
+// droppingWriter closes the connection once limit bytes of the body have been written.
+type droppingWriter struct {
+	http.ResponseWriter
+	limit int64
+}
+
+func (w *droppingWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) <= w.limit {
+		w.limit -= int64(len(p))
+		return w.ResponseWriter.Write(p)
+	}
+	_, _ = w.ResponseWriter.Write(p[:w.limit]) //nolint:errcheck // the connection is closed either way
+	w.Flush()
+	// the server closes the connection without finishing the response
+	panic(http.ErrAbortHandler)
+}
+
+func (w *droppingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// slowWriter writes in small chunks, sleeping before each one.
+type slowWriter struct {
+	http.ResponseWriter
+	delay time.Duration
+}
+
+const slowChunkSize = 16 * 1024
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > slowChunkSize {
+			chunk = chunk[:slowChunkSize]
+		}
+		time.Sleep(w.delay)
+		m, err := w.ResponseWriter.Write(chunk)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		if f, ok := w.ResponseWriter.(http.Flusher); ok {
+			f.Flush()
+		}
+		p = p[m:]
+	}
+	return n, nil
+}
+
+// ReadFrom hides the embedded ResponseWriter's io.ReaderFrom so that io.Copy goes through Write.
+func (w *slowWriter) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(struct{ io.Writer }{w}, r)
+}
@@ -0,0 +1,205 @@
+package gharchivetest_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/require"
+	"github.com/willabides/gharchive-client"
+	"github.com/willabides/gharchive-client/gharchivetest"
+)
+
+func setupServer(ctx context.Context, t *testing.T) (*gharchivetest.Server, *storage.Client) {
+	t.Helper()
+	server := gharchivetest.NewServer(nil)
+	t.Cleanup(server.Close)
+	client, err := server.Client(ctx)
+	require.NoError(t, err)
+	return server, client
+}
+
+func scanAll(ctx context.Context, t *testing.T, start time.Time, opts *gharchive.Options) ([]string, error) {
+	t.Helper()
+	opts.Validators = append(opts.Validators, gharchive.ValidateNotEmpty())
+	scanner, err := gharchive.New(ctx, start, opts)
+	require.NoError(t, err)
+	var got []string
+	for scanner.Scan(ctx) {
+		got = append(got, string(scanner.Bytes()))
+	}
+	return got, scanner.Err()
+}
+
+func TestServer(t *testing.T) {
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+
+	t.Run("serves hours", func(t *testing.T) {
+		ctx := context.Background()
+		server, client := setupServer(ctx, t)
+		var want []string
+		for i := 0; i < 3; i++ {
+			hour := start.Add(time.Duration(i) * time.Hour)
+			lines := gharchivetest.SyntheticLines(hour, 50)
+			require.NoError(t, server.SetHourLines(hour, lines))
+			for _, line := range lines {
+				want = append(want, string(line))
+			}
+		}
+		got, err := scanAll(ctx, t, start, &gharchive.Options{
+			StorageClient: client,
+			EndTime:       start.Add(3 * time.Hour),
+			PreserveOrder: true,
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, want, got)
+		require.Equal(t, 1, server.Requests(start))
+	})
+
+	t.Run("missing hour", func(t *testing.T) {
+		ctx := context.Background()
+		server, client := setupServer(ctx, t)
+		require.NoError(t, server.SetHourLines(start, gharchivetest.SyntheticLines(start, 5)))
+		got, err := scanAll(ctx, t, start, &gharchive.Options{
+			StorageClient: client,
+			EndTime:       start.Add(2 * time.Hour),
+			PreserveOrder: true,
+		})
+		require.Equal(t, storage.ErrObjectNotExist, err)
+		require.Len(t, got, 5)
+	})
+
+	t.Run("injected error", func(t *testing.T) {
+		ctx := context.Background()
+		server, client := setupServer(ctx, t)
+		require.NoError(t, server.SetHourLines(start, gharchivetest.SyntheticLines(start, 5)))
+		server.SetFault(start, &gharchivetest.Fault{
+			StatusCode: http.StatusForbidden,
+		})
+		_, err := scanAll(ctx, t, start, &gharchive.Options{
+			StorageClient: client,
+			SingleHour:    true,
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "403")
+	})
+
+	t.Run("truncated gzip", func(t *testing.T) {
+		ctx := context.Background()
+		server, client := setupServer(ctx, t)
+		require.NoError(t, server.SetHourLines(start, gharchivetest.SyntheticLines(start, 1000)))
+		server.SetFault(start, &gharchivetest.Fault{
+			TruncateAt: 2000,
+		})
+		_, err := scanAll(ctx, t, start, &gharchive.Options{
+			StorageClient: client,
+			SingleHour:    true,
+		})
+		require.Error(t, err)
+	})
+
+	t.Run("dropped at a gzip member boundary", func(t *testing.T) {
+		ctx := context.Background()
+		server, client := setupServer(ctx, t)
+		lines := gharchivetest.SyntheticLines(start, 1000)
+		first, err := gharchivetest.Gzip(lines[:500])
+		require.NoError(t, err)
+		second, err := gharchivetest.Gzip(lines[500:])
+		require.NoError(t, err)
+		server.SetHour(start, append(first, second...))
+		server.SetFault(start, &gharchivetest.Fault{
+			DropAfter: int64(len(first)),
+		})
+		_, err = scanAll(ctx, t, start, &gharchive.Options{
+			StorageClient: client,
+			SingleHour:    true,
+		})
+		require.True(t, errors.Is(err, io.ErrUnexpectedEOF), "got %v", err)
+	})
+
+	t.Run("bad checksum", func(t *testing.T) {
+		ctx := context.Background()
+		server, client := setupServer(ctx, t)
+		require.NoError(t, server.SetHourLines(start, gharchivetest.SyntheticLines(start, 1000)))
+		server.SetFault(start, &gharchivetest.Fault{
+			BadCRC32C: true,
+		})
+		_, err := scanAll(ctx, t, start, &gharchive.Options{
+			StorageClient: client,
+			SingleHour:    true,
+		})
+		require.True(t, errors.Is(err, gharchive.ErrChecksumMismatch), "got %v", err)
+		var checksumErr *gharchive.ChecksumError
+		require.True(t, errors.As(err, &checksumErr))
+		require.Equal(t, gharchive.ObjectName(start), checksumErr.Name)
+		require.Equal(t, checksumErr.WantSize, checksumErr.GotSize)
+		require.NotEqual(t, checksumErr.WantCRC32C, checksumErr.GotCRC32C)
+	})
+
+	t.Run("slow reads", func(t *testing.T) {
+		ctx := context.Background()
+		server, client := setupServer(ctx, t)
+		require.NoError(t, server.SetHourLines(start, gharchivetest.SyntheticLines(start, 1000)))
+		server.SetFault(start, &gharchivetest.Fault{
+			ReadDelay: 10 * time.Millisecond,
+		})
+		got, err := scanAll(ctx, t, start, &gharchive.Options{
+			StorageClient: client,
+			SingleHour:    true,
+		})
+		require.NoError(t, err)
+		require.Len(t, got, 1000)
+	})
+
+	t.Run("range reads", func(t *testing.T) {
+		ctx := context.Background()
+		server, client := setupServer(ctx, t)
+		// gzip members that split lines so the seek index has checkpoints to use
+		content := bytes.Join(gharchivetest.SyntheticLines(start, 3000), nil)
+		var data []byte
+		for len(content) > 0 {
+			n := 20_000
+			if n > len(content) {
+				n = len(content)
+			}
+			member, err := gharchivetest.Gzip([][]byte{content[:n]})
+			require.NoError(t, err)
+			data = append(data, member...)
+			content = content[n:]
+		}
+		name := gharchive.ObjectName(start)
+		index, err := gharchive.BuildSeekIndex(bytes.NewReader(data), name)
+		require.NoError(t, err)
+		var indexData bytes.Buffer
+		_, err = index.WriteTo(&indexData)
+		require.NoError(t, err)
+		server.SetObject(name, data)
+		server.SetObject(gharchive.SeekIndexName(name), indexData.Bytes())
+		scan := func(seek bool) ([]string, gharchive.Progress) {
+			t.Helper()
+			var progress gharchive.Progress
+			got, err := scanAll(ctx, t, start.Add(45*time.Minute), &gharchive.Options{
+				StorageClient:   client,
+				EndTime:         start.Add(time.Hour),
+				StrictTimeRange: true,
+				SeekIndex:       seek,
+				Progress: func(p gharchive.Progress) {
+					progress = p
+				},
+			})
+			require.NoError(t, err)
+			return got, progress
+		}
+		want, fullProgress := scan(false)
+		require.NotEmpty(t, want)
+		got, seekProgress := scan(true)
+		require.Equal(t, want, got)
+		require.Less(t, seekProgress.BytesDownloaded, fullProgress.BytesDownloaded/2)
+	})
+}
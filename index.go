@@ -0,0 +1,303 @@
+package gharchive
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IndexFields are the fields that an HourIndex records values of.
+var IndexFields = []string{"repo.name", "actor.login", "org.login", "type"}
+
+// IndexExt is the extension of hour index files. An hour's index file is named by IndexName.
+const IndexExt = ".idx"
+
+// IndexName returns the name of the index file for an object like 2020-10-01-5.idx for 2020-10-01-5.json.gz.
+func IndexName(objectName string) string {
+	return indexFileName(objectName, IndexExt)
+}
+
+// indexFileName replaces GzipExt or ZstdExt at the end of objectName with ext or appends ext for other names.
+func indexFileName(objectName, ext string) string {
+	for _, objectExt := range []string{GzipExt, ZstdExt} {
+		if strings.HasSuffix(objectName, objectExt) {
+			return strings.TrimSuffix(objectName, objectExt) + ext
+		}
+	}
+	return objectName + ext
+}
+
+// indexFalsePositiveRate is the false positive rate of the bloom filters in an HourIndex.
+const indexFalsePositiveRate = 0.01
+
+// indexMagic starts an encoded HourIndex. The last byte is the format version.
+var indexMagic = []byte("GHAIDX\x01")
+
+// HourIndex records which values of IndexFields may appear in an hour with a bloom filter for each field. Values are
+// compared case-insensitively.
+type HourIndex struct {
+	Lines   int64 // number of lines in the hour
+	filters map[string]*bloomFilter
+}
+
+// MayContain returns false when no event in the hour has value in field. It returns true when one may, including
+// when field isn't indexed.
+func (x *HourIndex) MayContain(field, value string) bool {
+	filter, ok := x.filters[field]
+	if !ok {
+		return true
+	}
+	return filter.has([]byte(strings.ToLower(value)))
+}
+
+// mayMatch returns false when filter has a field with values and none of them may be in the hour.
+func (x *HourIndex) mayMatch(filter map[string][]string) bool {
+	for field, values := range filter {
+		if len(values) == 0 {
+			continue
+		}
+		found := false
+		for _, value := range values {
+			if x.MayContain(field, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// indexBuilder collects the distinct values of IndexFields in an hour's lines.
+type indexBuilder struct {
+	values map[string]map[string]struct{}
+	lines  int64
+}
+
+func newIndexBuilder() *indexBuilder {
+	b := &indexBuilder{
+		values: map[string]map[string]struct{}{},
+	}
+	for _, field := range IndexFields {
+		b.values[field] = map[string]struct{}{}
+	}
+	return b
+}
+
+func (b *indexBuilder) add(line []byte) {
+	b.lines++
+	for _, field := range IndexFields {
+		val, ok := jsonPathValue(line, strings.Split(field, "."))
+		if ok && len(val) > 0 {
+			b.values[field][strings.ToLower(string(val))] = struct{}{}
+		}
+	}
+}
+
+func (b *indexBuilder) index() *HourIndex {
+	x := &HourIndex{
+		Lines:   b.lines,
+		filters: map[string]*bloomFilter{},
+	}
+	for field, values := range b.values {
+		filter := newBloomFilter(len(values), indexFalsePositiveRate)
+		for value := range values {
+			filter.add([]byte(value))
+		}
+		x.filters[field] = filter
+	}
+	return x
+}
+
+// IndexHour scans the object for the hour containing hour with opts and returns its index. Legacy events are indexed
+// in the modern format. Validators, Dedupe, StrictTimeRange and IndexFilter in opts are ignored. Unlike
+// Scanner.Close, IndexHour doesn't close opts.StorageClient.
+func IndexHour(ctx context.Context, hour time.Time, opts *Options) (*HourIndex, error) {
+	ownClient := opts == nil || opts.StorageClient == nil && opts.Source == nil
+	opts, err := opts.withDefaults(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ownClient {
+		defer func() {
+			_ = opts.StorageClient.Close() //nolint:errcheck // nothing to do with this error
+		}()
+	}
+	scanOpts := new(Options)
+	*scanOpts = *opts
+	// the scanner closes StorageClient, so it reads through a Source instead
+	scanOpts.Source = opts.source()
+	scanOpts.StorageClient = nil
+	scanOpts.SingleHour = true
+	scanOpts.NormalizeLegacy = true
+	scanOpts.Validators = []Validator{ValidateNotEmpty()}
+	scanOpts.ValidationConcurrency = 0
+	scanOpts.Dedupe = nil
+	scanOpts.StrictTimeRange = false
+	scanOpts.IndexFilter = nil
+	scanOpts.Progress = nil
+	scanner, err := New(ctx, hour, scanOpts)
+	if err != nil {
+		return nil, err
+	}
+	builder := newIndexBuilder()
+	for scanner.Scan(ctx) {
+		builder.add(scanner.Bytes())
+	}
+	err = scanner.Err()
+	closeErr := scanner.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return builder.index(), nil
+}
+
+// WriteTo writes x in the format that ReadHourIndex reads.
+func (x *HourIndex) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	buf.Write(indexMagic)
+	writeUvarint := func(v uint64) {
+		var tmp [binary.MaxVarintLen64]byte
+		buf.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+	}
+	writeUvarint(uint64(x.Lines))
+	fields := make([]string, 0, len(x.filters))
+	for field := range x.filters {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	writeUvarint(uint64(len(fields)))
+	for _, field := range fields {
+		filter := x.filters[field]
+		writeUvarint(uint64(len(field)))
+		buf.WriteString(field)
+		writeUvarint(filter.k)
+		writeUvarint(uint64(len(filter.bits)))
+		for _, word := range filter.bits {
+			var tmp [8]byte
+			binary.LittleEndian.PutUint64(tmp[:], word)
+			buf.Write(tmp[:])
+		}
+	}
+	n, err := w.Write(appendIndexChecksum(buf.Bytes()))
+	return int64(n), err
+}
+
+// appendIndexChecksum appends the CRC32C checksum of data to it.
+func appendIndexChecksum(data []byte) []byte {
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], crc32.Checksum(data, crc32cTable))
+	return append(data, sum[:]...)
+}
+
+// checkIndexData checks the magic and checksum of an index file and returns the data between them.
+func checkIndexData(data, magic []byte) ([]byte, error) {
+	if len(data) < len(magic)+4 || !bytes.Equal(data[:len(magic)], magic) {
+		return nil, ErrInvalidIndex
+	}
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.Checksum(body, crc32cTable) != sum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidIndex)
+	}
+	return body[len(magic):], nil
+}
+
+// ErrInvalidIndex is returned by ReadHourIndex and ReadSeekIndex for data that isn't a complete index.
+var ErrInvalidIndex = errors.New("invalid hour index")
+
+// ReadHourIndex reads an HourIndex written by HourIndex.WriteTo.
+func ReadHourIndex(r io.Reader) (*HourIndex, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	body, err := checkIndexData(data, indexMagic)
+	if err != nil {
+		return nil, err
+	}
+	br := bytes.NewReader(body)
+	lines, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, ErrInvalidIndex
+	}
+	fieldCount, err := binary.ReadUvarint(br)
+	if err != nil || fieldCount > uint64(len(body)) {
+		return nil, ErrInvalidIndex
+	}
+	x := &HourIndex{
+		Lines:   int64(lines),
+		filters: map[string]*bloomFilter{},
+	}
+	for i := uint64(0); i < fieldCount; i++ {
+		nameLen, err := binary.ReadUvarint(br)
+		if err != nil || nameLen > uint64(br.Len()) {
+			return nil, ErrInvalidIndex
+		}
+		name := make([]byte, nameLen)
+		_, err = io.ReadFull(br, name)
+		if err != nil {
+			return nil, ErrInvalidIndex
+		}
+		k, err := binary.ReadUvarint(br)
+		if err != nil || k == 0 || k > bloomMaxHashes {
+			return nil, ErrInvalidIndex
+		}
+		words, err := binary.ReadUvarint(br)
+		if err != nil || words == 0 || words > uint64(br.Len())/8 {
+			return nil, ErrInvalidIndex
+		}
+		filter := &bloomFilter{
+			bits: make([]uint64, words),
+			k:    k,
+		}
+		err = binary.Read(br, binary.LittleEndian, filter.bits)
+		if err != nil {
+			return nil, ErrInvalidIndex
+		}
+		x.filters[string(name)] = filter
+	}
+	if br.Len() > 0 {
+		return nil, ErrInvalidIndex
+	}
+	return x, nil
+}
+
+// indexSource returns o.IndexSource or the Source objects are read from when it isn't set. o must have defaults.
+func (o *Options) indexSource() Source {
+	if o.IndexSource != nil {
+		return o.IndexSource
+	}
+	return o.source()
+}
+
+// indexFilterSkips returns true when the index for the object named name in src shows that the hour can't match
+// filter. Hours without a usable index aren't skipped.
+func indexFilterSkips(ctx context.Context, src Source, name string, filter map[string][]string) (bool, error) {
+	rdr, err := src.Open(ctx, IndexName(name))
+	if err == ErrObjectNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = rdr.Close() //nolint:errcheck // read only
+	}()
+	x, err := ReadHourIndex(rdr)
+	if err != nil {
+		return false, err
+	}
+	return !x.mayMatch(filter), nil
+}
@@ -0,0 +1,150 @@
+package gharchive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHourIndex(t *testing.T) {
+	builder := newIndexBuilder()
+	for i := 0; i < 1000; i++ {
+		builder.add([]byte(fmt.Sprintf(`{"type":"PushEvent","actor":{"login":"User%d"},"repo":{"name":"org/repo%d"}}`, i, i)))
+	}
+	index := builder.index()
+	var buf bytes.Buffer
+	_, err := index.WriteTo(&buf)
+	require.NoError(t, err)
+	got, err := ReadHourIndex(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, index, got)
+
+	require.Equal(t, int64(1000), got.Lines)
+	require.True(t, got.MayContain("actor.login", "user5"))
+	require.True(t, got.MayContain("repo.name", "ORG/REPO999"))
+	require.True(t, got.MayContain("type", "pushevent"))
+	require.True(t, got.MayContain("payload.action", "anything"), "fields that aren't indexed may contain anything")
+	var falsePositives int
+	for i := 0; i < 1000; i++ {
+		if got.MayContain("repo.name", fmt.Sprintf("other/repo%d", i)) {
+			falsePositives++
+		}
+	}
+	require.Less(t, falsePositives, 50)
+	require.False(t, got.MayContain("org.login", "org"), "no event has an org")
+	require.False(t, got.mayMatch(map[string][]string{
+		"type":      {"PushEvent"},
+		"repo.name": {"other/repo"},
+	}))
+	require.True(t, got.mayMatch(map[string][]string{
+		"type":      {"WatchEvent", "PushEvent"},
+		"repo.name": {"org/repo1"},
+		"org.login": nil,
+	}))
+
+	for _, data := range [][]byte{
+		nil,
+		buf.Bytes()[:buf.Len()-1],
+		append([]byte("x"), buf.Bytes()[1:]...),
+		append(buf.Bytes()[:buf.Len()-5:buf.Len()-5], buf.Bytes()[buf.Len()-4:]...),
+	} {
+		_, err = ReadHourIndex(bytes.NewReader(data))
+		require.True(t, errors.Is(err, ErrInvalidIndex))
+	}
+
+	// newBloomFilter never uses these hash counts. a huge one would make every lookup slow.
+	for _, k := range []uint64{0, bloomMaxHashes + 1} {
+		index.filters["type"].k = k
+		buf.Reset()
+		_, err = index.WriteTo(&buf)
+		require.NoError(t, err)
+		_, err = ReadHourIndex(bytes.NewReader(buf.Bytes()))
+		require.True(t, errors.Is(err, ErrInvalidIndex), "k=%d", k)
+	}
+}
+
+func TestIndexName(t *testing.T) {
+	require.Equal(t, "2020-10-01-5.idx", IndexName("2020-10-01-5.json.gz"))
+	require.Equal(t, "2020-10-01-5.idx", IndexName("2020-10-01-5.json.zst"))
+	require.Equal(t, "2020-10-01.ndjson.idx", IndexName("2020-10-01.ndjson"))
+}
+
+func TestValidateFieldValues(t *testing.T) {
+	validate := ValidateFieldValues(map[string][]string{
+		"repo.name": {"Org/Repo", "org/other"},
+		"type":      {"PushEvent"},
+	})
+	require.True(t, validate([]byte(`{"type":"PushEvent","repo":{"name":"org/repo"}}`)))
+	require.False(t, validate([]byte(`{"type":"WatchEvent","repo":{"name":"org/repo"}}`)))
+	require.False(t, validate([]byte(`{"type":"PushEvent","repo":{"name":"org/third"}}`)))
+	require.False(t, validate([]byte(`{"type":"PushEvent"}`)))
+
+	t.Run("values changed after", func(t *testing.T) {
+		values := map[string][]string{
+			"repo.name": {"org/repo"},
+		}
+		validate := ValidateFieldValues(values)
+		values["type"] = []string{"PushEvent"}
+		values["repo.name"][0] = "org/other"
+		require.True(t, validate([]byte(`{"type":"WatchEvent","repo":{"name":"org/repo"}}`)))
+	})
+}
+
+func TestScanner_IndexFilter(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	end := start.Add(4 * time.Hour)
+	src := writeEventHours(t, start, end, 50)
+	// the last hour doesn't have an index
+	for hour := start; hour.Before(end.Add(-time.Hour)); hour = hour.Add(time.Hour) {
+		index, err := IndexHour(ctx, hour, &Options{Source: src})
+		require.NoError(t, err)
+		require.Equal(t, int64(50), index.Lines)
+		var buf bytes.Buffer
+		_, err = index.WriteTo(&buf)
+		require.NoError(t, err)
+		writeObject(t, src, IndexName(ObjectName(hour)), buf.Bytes())
+	}
+	var event struct {
+		Repo struct {
+			Name string `json:"name"`
+		} `json:"repo"`
+	}
+	require.NoError(t, json.Unmarshal(testEventLines(start.Add(time.Hour), 50)[0], &event))
+	filter := map[string][]string{
+		"repo.name": {strings.ToUpper(event.Repo.Name)},
+		"type":      {"PushEvent", "WatchEvent", "IssuesEvent", "CreateEvent", "ForkEvent"},
+	}
+	want := scanLines(t, start, &Options{
+		Source:        src,
+		EndTime:       end,
+		PreserveOrder: true,
+		Validators:    []Validator{ValidateFieldValues(filter)},
+	})
+	require.NotEmpty(t, want)
+
+	for _, concurrency := range []int{1, 3} {
+		skipped := map[time.Time]bool{}
+		got := scanLines(t, start, &Options{
+			Source:      src,
+			EndTime:     end,
+			Concurrency: concurrency,
+			Validators:  []Validator{ValidateFieldValues(filter)},
+			IndexFilter: filter,
+			Progress: func(p Progress) {
+				if p.Skipped {
+					skipped[p.Hour] = true
+				}
+			},
+		})
+		require.ElementsMatch(t, want, got)
+		require.Equal(t, map[time.Time]bool{start: true, start.Add(2 * time.Hour): true}, skipped)
+	}
+}
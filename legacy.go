@@ -0,0 +1,225 @@
+package gharchive
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// legacyEnd is when gharchive switched from the Timeline API to the Events API. Hours before it may have events
+// in the legacy Timeline format.
+var legacyEnd = time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// legacyLayouts are created_at layouts used by Timeline events.
+var legacyLayouts = []string{
+	time.RFC3339,
+	"2006/01/02 15:04:05 -0700",
+}
+
+// legacyEvent is an event from the Timeline API.
+type legacyEvent struct {
+	ID              jsoniter.RawMessage `json:"id"`
+	Type            string              `json:"type"`
+	Public          *bool               `json:"public"`
+	CreatedAt       string              `json:"created_at"`
+	Actor           jsoniter.RawMessage `json:"actor"`
+	ActorAttributes *struct {
+		Login      string `json:"login"`
+		GravatarID string `json:"gravatar_id"`
+	} `json:"actor_attributes"`
+	Repository *struct {
+		ID           jsoniter.RawMessage `json:"id"`
+		Name         string              `json:"name"`
+		Owner        string              `json:"owner"`
+		Organization string              `json:"organization"`
+	} `json:"repository"`
+	Payload jsoniter.RawMessage `json:"payload"`
+}
+
+// modernEvent is the Events API envelope that legacy events are mapped to.
+type modernEvent struct {
+	ID        jsoniter.RawMessage `json:"id,omitempty"`
+	Type      string              `json:"type"`
+	Actor     *modernAccount      `json:"actor,omitempty"`
+	Repo      *modernRepo         `json:"repo,omitempty"`
+	Payload   jsoniter.RawMessage `json:"payload,omitempty"`
+	Public    *bool               `json:"public,omitempty"`
+	CreatedAt string              `json:"created_at"`
+	Org       *modernAccount      `json:"org,omitempty"`
+}
+
+type modernAccount struct {
+	Login      string `json:"login"`
+	GravatarID string `json:"gravatar_id,omitempty"`
+	URL        string `json:"url"`
+}
+
+type modernRepo struct {
+	ID   jsoniter.RawMessage `json:"id,omitempty"`
+	Name string              `json:"name"`
+	URL  string              `json:"url"`
+}
+
+// NormalizeLegacyEvent maps an event in the Timeline format that gharchive used before 2015 to the modern Events API
+// envelope with actor, repo, org, payload and created_at fields. Legacy lists of commits in PushEvent payloads are
+// mapped to modern commits. ok is false and line is returned as is when it isn't a legacy event. A trailing newline
+// is preserved.
+func NormalizeLegacyEvent(line []byte) (normalized []byte, ok bool) {
+	if !isLegacyEvent(line) {
+		return line, false
+	}
+	var legacy legacyEvent
+	err := jsoniter.ConfigFastest.Unmarshal(line, &legacy)
+	if err != nil {
+		return line, false
+	}
+	event := modernEvent{
+		ID:        legacy.ID,
+		Type:      legacy.Type,
+		Payload:   legacy.Payload,
+		Public:    legacy.Public,
+		CreatedAt: legacy.CreatedAt,
+	}
+	for _, layout := range legacyLayouts {
+		createdAt, parseErr := time.Parse(layout, legacy.CreatedAt)
+		if parseErr == nil {
+			event.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+			break
+		}
+	}
+	event.Actor = legacyActor(&legacy)
+	if repo := legacy.Repository; repo != nil {
+		name := repo.Name
+		if repo.Owner != "" {
+			name = repo.Owner + "/" + repo.Name
+		}
+		event.Repo = &modernRepo{
+			ID:   repo.ID,
+			Name: name,
+			URL:  "https://api.github.com/repos/" + name,
+		}
+		if repo.Organization != "" {
+			event.Org = &modernAccount{
+				Login: repo.Organization,
+				URL:   "https://api.github.com/orgs/" + repo.Organization,
+			}
+		}
+	}
+	if legacy.Type == "PushEvent" {
+		event.Payload = legacyPushPayload(legacy.Payload)
+	}
+	normalized, err = jsoniter.ConfigFastest.Marshal(&event)
+	if err != nil {
+		return line, false
+	}
+	if bytes.HasSuffix(line, []byte("\n")) {
+		normalized = append(normalized, '\n')
+	}
+	return normalized, true
+}
+
+// isLegacyEvent returns true when line is a json object with a top-level repository field or a string actor.
+func isLegacyEvent(line []byte) bool {
+	iter := jsoniter.ConfigFastest.BorrowIterator(line)
+	defer jsoniter.ConfigFastest.ReturnIterator(iter)
+	legacy := false
+	iter.ReadObjectCB(func(iter *jsoniter.Iterator, field string) bool {
+		switch {
+		case field == "repository" || field == "actor_attributes":
+			legacy = true
+		case field == "actor" && iter.WhatIsNext() == jsoniter.StringValue:
+			legacy = true
+		default:
+			iter.Skip()
+		}
+		return !legacy
+	})
+	return legacy
+}
+
+func legacyActor(legacy *legacyEvent) *modernAccount {
+	var login string
+	if len(legacy.Actor) > 0 && legacy.Actor[0] == '"' {
+		_ = jsoniter.ConfigFastest.Unmarshal(legacy.Actor, &login) //nolint:errcheck // login stays empty
+	}
+	var gravatarID string
+	if attrs := legacy.ActorAttributes; attrs != nil {
+		if attrs.Login != "" {
+			login = attrs.Login
+		}
+		gravatarID = attrs.GravatarID
+	}
+	if login == "" {
+		return nil
+	}
+	return &modernAccount{
+		Login:      login,
+		GravatarID: gravatarID,
+		URL:        "https://api.github.com/users/" + login,
+	}
+}
+
+// legacyPushPayload maps the shas field of a legacy PushEvent payload to the commits field of a modern one. Each
+// sha is a list of [sha, author email, message, author name, distinct].
+func legacyPushPayload(payload jsoniter.RawMessage) jsoniter.RawMessage {
+	// encoding/json because the jsoniter version in use can't handle maps with recent versions of go
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(payload, &fields) != nil {
+		return payload
+	}
+	var shas [][]interface{}
+	if _, ok := fields["shas"]; !ok || json.Unmarshal(fields["shas"], &shas) != nil {
+		return payload
+	}
+	type commitAuthor struct {
+		Email string `json:"email"`
+		Name  string `json:"name"`
+	}
+	type commit struct {
+		SHA      string       `json:"sha"`
+		Author   commitAuthor `json:"author"`
+		Message  string       `json:"message"`
+		Distinct bool         `json:"distinct"`
+	}
+	commits := make([]commit, 0, len(shas))
+	for _, sha := range shas {
+		var c commit
+		for i, val := range sha {
+			str, _ := val.(string)
+			switch i {
+			case 0:
+				c.SHA = str
+			case 1:
+				c.Author.Email = str
+			case 2:
+				c.Message = str
+			case 3:
+				c.Author.Name = str
+			case 4:
+				c.Distinct, _ = val.(bool)
+			}
+		}
+		commits = append(commits, c)
+	}
+	var err error
+	fields["commits"], err = jsoniter.ConfigFastest.Marshal(commits)
+	if err != nil {
+		return payload
+	}
+	delete(fields, "shas")
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err = enc.Encode(fields)
+	if err != nil {
+		return payload
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// legacyHour returns true when hour may have events in the legacy Timeline format.
+func legacyHour(hour time.Time) bool {
+	return hour.Before(legacyEnd)
+}
@@ -0,0 +1,83 @@
+package gharchive
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeLegacyEvent(t *testing.T) {
+	t.Run("watch event", func(t *testing.T) {
+		line := `{"created_at":"2012-03-10T22:00:15-08:00","payload":{"action":"started"},"public":true,"type":"WatchEvent",` +
+			`"url":"https://github.com/octo-org/hello","actor":"octocat","actor_attributes":{"login":"octocat","type":"User",` +
+			`"gravatar_id":"abc","name":"Mona"},"repository":{"id":42,"name":"hello","url":"https://github.com/octo-org/hello",` +
+			`"owner":"octo-org","organization":"octo-org","watchers":3}}` + "\n"
+		got, ok := NormalizeLegacyEvent([]byte(line))
+		require.True(t, ok)
+		require.JSONEq(t, `{
+			"type": "WatchEvent",
+			"actor": {"login": "octocat", "gravatar_id": "abc", "url": "https://api.github.com/users/octocat"},
+			"repo": {"id": 42, "name": "octo-org/hello", "url": "https://api.github.com/repos/octo-org/hello"},
+			"payload": {"action": "started"},
+			"public": true,
+			"created_at": "2012-03-11T06:00:15Z",
+			"org": {"login": "octo-org", "url": "https://api.github.com/orgs/octo-org"}
+		}`, string(got))
+		require.Equal(t, byte('\n'), got[len(got)-1])
+	})
+
+	t.Run("push event", func(t *testing.T) {
+		line := `{"created_at":"2011/02/12 08:00:00 -0800","type":"PushEvent","actor":"octocat",` +
+			`"repository":{"name":"hello","owner":"octocat"},` +
+			`"payload":{"ref":"refs/heads/main","size":1,"shas":[["abc123","o@example.com","fix it","Octo",true]]}}`
+		got, ok := NormalizeLegacyEvent([]byte(line))
+		require.True(t, ok)
+		require.JSONEq(t, `{
+			"type": "PushEvent",
+			"actor": {"login": "octocat", "url": "https://api.github.com/users/octocat"},
+			"repo": {"name": "octocat/hello", "url": "https://api.github.com/repos/octocat/hello"},
+			"payload": {"ref": "refs/heads/main", "size": 1, "commits": [
+				{"sha": "abc123", "author": {"email": "o@example.com", "name": "Octo"}, "message": "fix it", "distinct": true}
+			]},
+			"created_at": "2011-02-12T16:00:00Z"
+		}`, string(got))
+	})
+
+	t.Run("modern event", func(t *testing.T) {
+		line := []byte(`{"id":"1","type":"WatchEvent","actor":{"login":"octocat"},"repo":{"name":"a/b"},"payload":{"repository":{}}}`)
+		got, ok := NormalizeLegacyEvent(line)
+		require.False(t, ok)
+		require.Equal(t, line, got)
+	})
+
+	t.Run("not json", func(t *testing.T) {
+		got, ok := NormalizeLegacyEvent([]byte("\n"))
+		require.False(t, ok)
+		require.Equal(t, []byte("\n"), got)
+	})
+}
+
+func TestScanner_NormalizeLegacy(t *testing.T) {
+	hour := time.Date(2012, 3, 11, 6, 0, 0, 0, time.UTC)
+	legacy := []byte(`{"created_at":"2012-03-10T22:00:15-08:00","type":"WatchEvent","actor":"octocat",` +
+		`"repository":{"id":42,"name":"hello","owner":"octo-org"},"payload":{"action":"started"}}` + "\n")
+	src := NewDirSource(t.TempDir())
+	writeObject(t, src, ObjectName(hour), gzipLines(t, [][]byte{legacy}))
+	opts := func(normalize bool) *Options {
+		return &Options{
+			Source:          src,
+			PreserveOrder:   true,
+			NormalizeLegacy: normalize,
+			Validators: []Validator{
+				ValidateSampleByField("repo.name", 1, 0),
+			},
+		}
+	}
+	require.Empty(t, scanLines(t, hour, opts(false)))
+	got := scanLines(t, hour, opts(true))
+	require.Len(t, got, 1)
+	want, ok := NormalizeLegacyEvent(legacy)
+	require.True(t, ok)
+	require.Equal(t, string(want), got[0])
+}
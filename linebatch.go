@@ -0,0 +1,158 @@
+package gharchive
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tuning constants for lineBatch
+const (
+	batchBufSize  = 256 * 1024
+	batchMaxLines = 1024
+)
+
+// lineBatch is a block of lines that share a single backing buffer. A batch has exactly one owner at a time.
+// Lines in a batch stay valid until the owner calls release.
+type lineBatch struct {
+	buf   []byte
+	lines [][]byte
+
+	// budget is charged for the batch's bytes while it is queued. release returns them.
+	budget  *byteBudget
+	charged int64
+
+	// pending counts the batch for hour until it is released
+	pending *pendingHours
+	hour    time.Time
+}
+
+var batchPool sync.Pool
+
+func newLineBatch() *lineBatch {
+	batch, ok := batchPool.Get().(*lineBatch)
+	if !ok {
+		batch = &lineBatch{
+			buf:   make([]byte, 0, batchBufSize),
+			lines: make([][]byte, 0, batchMaxLines),
+		}
+	}
+	return batch
+}
+
+// full returns true when a line of size n won't fit in the batch.
+func (b *lineBatch) full(n int) bool {
+	if len(b.lines) == 0 {
+		return false
+	}
+	return len(b.lines) >= batchMaxLines || len(b.buf)+n > cap(b.buf)
+}
+
+// add copies line into the batch.
+func (b *lineBatch) add(line []byte) {
+	start := len(b.buf)
+	b.buf = append(b.buf, line...)
+	b.lines = append(b.lines, b.buf[start:len(b.buf):len(b.buf)])
+}
+
+// charge waits for room in budget for the batch's bytes. They are returned to budget when the batch is released.
+func (b *lineBatch) charge(ctx context.Context, budget *byteBudget) error {
+	size := int64(len(b.buf))
+	err := budget.acquire(ctx, size)
+	if err != nil {
+		return err
+	}
+	b.budget = budget
+	b.charged = size
+	return nil
+}
+
+// track counts the batch as pending for hour until it is released. It does nothing when pending is nil.
+func (b *lineBatch) track(pending *pendingHours, hour time.Time) {
+	pending.add(hour, 1)
+	b.pending = pending
+	b.hour = hour
+}
+
+// filter removes lines for which keep returns false.
+func (b *lineBatch) filter(keep func(line []byte) bool) {
+	kept := b.lines[:0]
+	for _, line := range b.lines {
+		if keep(line) {
+			kept = append(kept, line)
+		}
+	}
+	for i := len(kept); i < len(b.lines); i++ {
+		b.lines[i] = nil
+	}
+	b.lines = kept
+}
+
+// reset empties the batch so it can be refilled. Lines previously in the batch are no longer valid.
+func (b *lineBatch) reset() {
+	if b.budget != nil {
+		b.budget.release(b.charged)
+		b.budget = nil
+		b.charged = 0
+	}
+	if b.pending != nil {
+		b.pending.add(b.hour, -1)
+		b.pending = nil
+	}
+	if cap(b.buf) > batchBufSize {
+		// don't hold on to buffers that grew for an unusually long line
+		b.buf = make([]byte, 0, batchBufSize)
+	}
+	b.buf = b.buf[:0]
+	for i := range b.lines {
+		b.lines[i] = nil
+	}
+	b.lines = b.lines[:0]
+}
+
+// release returns the batch to the pool. Neither the batch nor its lines may be used afterward.
+func (b *lineBatch) release() {
+	b.reset()
+	batchPool.Put(b)
+}
+
+// batchIterator implements line-at-a-time scanning on top of a source of batches.
+type batchIterator struct {
+	lines [][]byte
+	idx   int
+}
+
+// scan advances to the next line, calling nextBatch when the current batch is used up.
+func (it *batchIterator) scan(ctx context.Context, nextBatch func(context.Context) [][]byte) bool {
+	it.idx++
+	for it.idx >= len(it.lines) {
+		it.lines = nextBatch(ctx)
+		it.idx = 0
+		if len(it.lines) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bytes returns the current line.
+func (it *batchIterator) bytes() []byte {
+	if it.idx >= len(it.lines) {
+		return nil
+	}
+	return it.lines[it.idx]
+}
+
+// nextBatch returns the lines in the current batch that haven't been scanned yet. When there aren't any, it
+// returns the result of calling nextBatch instead. Either way, the returned lines are marked as scanned.
+func (it *batchIterator) nextBatch(ctx context.Context, nextBatch func(context.Context) [][]byte) [][]byte {
+	var lines [][]byte
+	if it.idx+1 < len(it.lines) {
+		lines = it.lines[it.idx+1:]
+	} else {
+		lines = nextBatch(ctx)
+	}
+	it.lines = lines
+	it.idx = len(lines)
+	return lines
+}
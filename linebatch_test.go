@@ -0,0 +1,107 @@
+package gharchive
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_lineBatch(t *testing.T) {
+	t.Run("full", func(t *testing.T) {
+		for _, td := range []struct {
+			name  string
+			lines int
+			bytes int
+			n     int
+			want  bool
+		}{
+			{name: "empty batch takes a line bigger than the buffer", n: batchBufSize + 1, want: false},
+			{name: "room left", lines: 1, bytes: 10, n: batchBufSize - 10, want: false},
+			{name: "out of bytes", lines: 1, bytes: 10, n: batchBufSize - 9, want: true},
+			{name: "out of lines", lines: batchMaxLines, bytes: batchMaxLines, n: 1, want: true},
+		} {
+			t.Run(td.name, func(t *testing.T) {
+				batch := newLineBatch()
+				defer batch.release()
+				for i := 0; i < td.lines; i++ {
+					batch.add(bytes.Repeat([]byte("x"), td.bytes/td.lines))
+				}
+				require.Equal(t, td.want, batch.full(td.n))
+			})
+		}
+	})
+
+	t.Run("lines don't share capacity", func(t *testing.T) {
+		batch := newLineBatch()
+		defer batch.release()
+		batch.add([]byte("foo\n"))
+		batch.add([]byte("bar\n"))
+		_ = append(batch.lines[0], "baz"...)
+		require.Equal(t, [][]byte{[]byte("foo\n"), []byte("bar\n")}, batch.lines)
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		batch := newLineBatch()
+		defer batch.release()
+		for _, line := range []string{"a", "bb", "c", "dd"} {
+			batch.add([]byte(line))
+		}
+		batch.filter(func(line []byte) bool {
+			return len(line) == 2
+		})
+		require.Equal(t, [][]byte{[]byte("bb"), []byte("dd")}, batch.lines)
+		require.Nil(t, batch.lines[:4][2])
+	})
+
+	t.Run("release returns the charge", func(t *testing.T) {
+		ctx := context.Background()
+		budget := newByteBudget(10)
+		batch := newLineBatch()
+		batch.add([]byte("0123456789"))
+		require.NoError(t, batch.charge(ctx, budget))
+		require.Equal(t, int64(10), budget.usage())
+		batch.release()
+		require.Equal(t, int64(0), budget.usage())
+	})
+}
+
+func Test_batchIterator(t *testing.T) {
+	ctx := context.Background()
+	// batches returns a nextBatch func that returns the given batches in order and then nil
+	batches := func(b ...[]string) func(context.Context) [][]byte {
+		return func(context.Context) [][]byte {
+			if len(b) == 0 {
+				return nil
+			}
+			var lines [][]byte
+			for _, line := range b[0] {
+				lines = append(lines, []byte(line))
+			}
+			b = b[1:]
+			return lines
+		}
+	}
+
+	t.Run("scan", func(t *testing.T) {
+		var it batchIterator
+		next := batches([]string{"a", "b"}, nil, []string{"c"})
+		var got []string
+		for it.scan(ctx, next) {
+			got = append(got, string(it.bytes()))
+		}
+		require.Equal(t, []string{"a", "b"}, got)
+		require.Nil(t, it.bytes())
+	})
+
+	t.Run("nextBatch after scan", func(t *testing.T) {
+		var it batchIterator
+		next := batches([]string{"a", "b", "c"}, []string{"d"})
+		require.True(t, it.scan(ctx, next))
+		require.Equal(t, "a", string(it.bytes()))
+		require.Equal(t, [][]byte{[]byte("b"), []byte("c")}, it.nextBatch(ctx, next))
+		require.Equal(t, [][]byte{[]byte("d")}, it.nextBatch(ctx, next))
+		require.False(t, it.scan(ctx, next))
+	})
+}
@@ -16,6 +16,12 @@ func (s *lineScanner) scan() bool {
 			return true
 		}
 		if s.br.extend() == 0 {
+			if s.br.err == errCorruptionSkipped {
+				// drop the partial line before the corrupt data and keep reading after it
+				s.br.release(len(s.br.window()))
+				s.br.err = nil
+				continue
+			}
 			s.pos = len(s.br.window())
 			return s.pos > 0
 		}
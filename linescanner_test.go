@@ -2,9 +2,13 @@ package gharchive
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/klauspost/compress/gzip"
 	"github.com/stretchr/testify/require"
@@ -37,3 +41,55 @@ func Test_lineScanner(t *testing.T) {
 	require.EqualError(t, ls.error(), "EOF")
 	require.Equal(t, 10, count)
 }
+
+func Test_lineScanner_lines(t *testing.T) {
+	long := strings.Repeat("x", 3*newBufferSize)
+	for _, td := range []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{name: "empty"},
+		{name: "lines", content: "a\nbb\n", want: []string{"a\n", "bb\n"}},
+		{name: "no trailing newline", content: "a\nbb", want: []string{"a\n", "bb"}},
+		{name: "blank lines are lines", content: "\n\na\n", want: []string{"\n", "\n", "a\n"}},
+		{name: "longer than the buffer", content: long + "\n" + long, want: []string{long + "\n", long}},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			for _, wrap := range []func(io.Reader) io.Reader{
+				func(r io.Reader) io.Reader { return r },
+				iotest.OneByteReader,
+				iotest.DataErrReader,
+			} {
+				ls := &lineScanner{
+					br: byteReader{
+						r:    wrap(strings.NewReader(td.content)),
+						data: make([]byte, 0, newBufferSize),
+					},
+				}
+				var got []string
+				for ls.scan() {
+					got = append(got, string(ls.bytes()))
+				}
+				require.Equal(t, td.want, got)
+				require.Equal(t, io.EOF, ls.error())
+			}
+		})
+	}
+
+	t.Run("read error", func(t *testing.T) {
+		readErr := errors.New("read error")
+		ls := &lineScanner{
+			br: byteReader{
+				r:    io.MultiReader(strings.NewReader("a\nb"), iotest.ErrReader(readErr)),
+				data: make([]byte, 0, newBufferSize),
+			},
+		}
+		var got []string
+		for ls.scan() {
+			got = append(got, string(ls.bytes()))
+		}
+		require.Equal(t, []string{"a\n", "b"}, got)
+		require.Equal(t, readErr, ls.error())
+	})
+}
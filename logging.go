@@ -0,0 +1,22 @@
+package gharchive
+
+import (
+	"context"
+	"log/slog"
+)
+
+// logger returns a logger for o.Logger or a logger that discards everything when it isn't set.
+func (o *Options) logger() *slog.Logger {
+	if o.Logger == nil {
+		return slog.New(discardHandler{})
+	}
+	return slog.New(o.Logger)
+}
+
+// discardHandler is a slog.Handler that discards all records.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (d discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return d }
+func (d discardHandler) WithGroup(string) slog.Handler           { return d }
@@ -0,0 +1,67 @@
+package gharchive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanner_Logger(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+	src := writeEventHours(t, start, end, 3000)
+	logMessages := func(buf *bytes.Buffer) map[string]int {
+		t.Helper()
+		messages := map[string]int{}
+		dec := json.NewDecoder(buf)
+		for dec.More() {
+			var record struct {
+				Msg string `json:"msg"`
+			}
+			require.NoError(t, dec.Decode(&record))
+			messages[record.Msg]++
+		}
+		return messages
+	}
+
+	t.Run("concurrent", func(t *testing.T) {
+		var buf bytes.Buffer
+		scanLines(t, start, &Options{
+			Source:      src,
+			EndTime:     end,
+			Concurrency: 2,
+			Logger:      slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		})
+		require.Equal(t, map[string]int{
+			"worker started": 2,
+			"opened hour":    2,
+			"finished hour":  2,
+			"worker stopped": 2,
+		}, logMessages(&buf))
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		var buf bytes.Buffer
+		scanCtx, cancel := context.WithCancel(ctx)
+		scanner, err := New(scanCtx, start, &Options{
+			Source:        src,
+			EndTime:       end,
+			PreserveOrder: true,
+			Logger:        slog.NewJSONHandler(&buf, nil),
+		})
+		require.NoError(t, err)
+		require.True(t, scanner.Scan(scanCtx))
+		cancel()
+		for scanner.Scan(scanCtx) {
+		}
+		require.Equal(t, context.Canceled, scanner.Err())
+		require.NoError(t, scanner.Close())
+		require.Equal(t, map[string]int{"scan canceled": 1}, logMessages(&buf))
+	})
+}
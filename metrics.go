@@ -0,0 +1,86 @@
+package gharchive
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Metrics receives measurements from a Scanner. Its methods may be called from multiple goroutines at once.
+// The gharchiveprom package has an implementation that exports them to Prometheus.
+type Metrics interface {
+	// HourRead is called when an hour file has been read to the end. latency is the time from requesting the file
+	// to reading the end of it.
+	HourRead(latency time.Duration, downloadedBytes, decompressedBytes int64)
+
+	// LinesScanned is called with the number of lines read from hour files since the last call.
+	LinesScanned(n int64)
+
+	// LinesRejected is called with the number of lines rejected by Options.Validators[validator] since the last call.
+	LinesRejected(validator int, n int64)
+
+	// Retried is called when storage responds with a status that the storage client retries. It is only called when
+	// Options.StorageClient isn't set.
+	Retried()
+
+	// QueueDepth is called with the number of batches of lines waiting for the consumer of a concurrent scan.
+	QueueDepth(batches int)
+}
+
+// lineCounts accumulates line counts so that Metrics and validatorStats aren't updated for every line.
+type lineCounts struct {
+	scanned  int64   // lines read from hour files
+	checked  int64   // lines checked by validators
+	rejected []int64 // lines rejected by each validator
+}
+
+// check counts a line checked by validators. rejectedBy is the index of the validator that rejected it or -1.
+func (c *lineCounts) check(rejectedBy int) {
+	c.checked++
+	if rejectedBy >= 0 {
+		for len(c.rejected) <= rejectedBy {
+			c.rejected = append(c.rejected, 0)
+		}
+		c.rejected[rejectedBy]++
+	}
+}
+
+// flush sends the counts to metrics and stats and resets them. metrics and stats may be nil.
+func (c *lineCounts) flush(metrics Metrics, stats *validatorStats) {
+	if stats != nil && c.checked > 0 {
+		stats.add(c.checked, c.rejected)
+	}
+	if metrics != nil {
+		if c.scanned > 0 {
+			metrics.LinesScanned(c.scanned)
+		}
+		for i, n := range c.rejected {
+			if n > 0 {
+				metrics.LinesRejected(i, n)
+			}
+		}
+	}
+	c.scanned = 0
+	c.checked = 0
+	for i := range c.rejected {
+		c.rejected[i] = 0
+	}
+}
+
+// retryTransport reports responses with a status that the storage client retries to Metrics.Retried and logger.
+type retryTransport struct {
+	base    http.RoundTripper
+	metrics Metrics
+	logger  *slog.Logger
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500) {
+		if t.metrics != nil {
+			t.metrics.Retried()
+		}
+		t.logger.Warn("storage request will be retried", "url", req.URL.String(), "status", resp.StatusCode)
+	}
+	return resp, err
+}
@@ -0,0 +1,112 @@
+package gharchive
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ObjectLayout determines the names of the objects that hold events and how much time each one covers.
+type ObjectLayout interface {
+	// ObjectName returns the name of the object with events from the period containing tm.
+	ObjectName(tm time.Time) string
+
+	// ParseObjectName returns the start of the period that an object name from ObjectName covers. It returns an
+	// error for names that ObjectName doesn't return.
+	ParseObjectName(name string) (time.Time, error)
+
+	// Period returns how much time each object covers. Periods start at multiples of Period since the zero time.
+	Period() time.Duration
+}
+
+// DateLayout is an ObjectLayout for objects named by the UTC date and hour they cover like gharchive's 2020-10-01-5.json.gz.
+type DateLayout struct {
+	Daily bool   // one object per day named like 2020-10-01.json.gz. default: one object per hour like 2020-10-01-5.json.gz
+	Ext   string // extension of object names. default: .json.gz
+}
+
+// defaultLayout is the layout used by data.gharchive.org.
+var defaultLayout ObjectLayout = DateLayout{}
+
+// Extensions for objects compressed with gzip like gharchive's and with zstd like those written by
+// "gharchive recompress". Scanners detect the compression from an object's content, not its name.
+const (
+	GzipExt = ".json.gz"
+	ZstdExt = ".json.zst"
+)
+
+// ZstdName returns the name of the zstd variant of an object name that ends with GzipExt. ok is false for other
+// names.
+func ZstdName(name string) (zstdName string, ok bool) {
+	if !strings.HasSuffix(name, GzipExt) {
+		return "", false
+	}
+	return strings.TrimSuffix(name, GzipExt) + ZstdExt, true
+}
+
+func (l DateLayout) ext() string {
+	if l.Ext == "" {
+		return GzipExt
+	}
+	return l.Ext
+}
+
+// ObjectName returns the name of the object with events from the hour or day containing tm.
+func (l DateLayout) ObjectName(tm time.Time) string {
+	tm = tm.UTC()
+	if l.Daily {
+		return tm.Format("2006-01-02") + l.ext()
+	}
+	// hours don't have a leading zero, so they can't be formatted with the date
+	return tm.Format("2006-01-02-") + strconv.Itoa(tm.Hour()) + l.ext()
+}
+
+// ParseObjectName returns the start of the hour or day that name covers.
+func (l DateLayout) ParseObjectName(name string) (time.Time, error) {
+	base := strings.TrimSuffix(name, l.ext())
+	if base == name {
+		return time.Time{}, fmt.Errorf("object name %q doesn't end in %q", name, l.ext())
+	}
+	if l.Daily {
+		day, err := time.Parse("2006-01-02", base)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid object name %q", name)
+		}
+		return day, nil
+	}
+	idx := strings.LastIndexByte(base, '-')
+	if idx == -1 {
+		return time.Time{}, fmt.Errorf("invalid object name %q", name)
+	}
+	day, err := time.Parse("2006-01-02", base[:idx])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid object name %q", name)
+	}
+	hour, err := strconv.Atoi(base[idx+1:])
+	// names with a leading zero or sign aren't from ObjectName
+	if err != nil || hour < 0 || hour > 23 || strconv.Itoa(hour) != base[idx+1:] {
+		return time.Time{}, fmt.Errorf("invalid object name %q", name)
+	}
+	return day.Add(time.Duration(hour) * time.Hour), nil
+}
+
+// Period returns a day for daily layouts and an hour otherwise.
+func (l DateLayout) Period() time.Duration {
+	if l.Daily {
+		return 24 * time.Hour
+	}
+	return time.Hour
+}
+
+// ObjectName returns the name gharchive uses for the file with events from the hour containing tm, like
+// 2020-10-01-5.json.gz.
+func ObjectName(tm time.Time) string {
+	return defaultLayout.ObjectName(tm)
+}
+
+// ParseObjectName returns the hour of a gharchive file name from ObjectName. ParseObjectName(ObjectName(tm)) is the
+// start of the hour containing tm.
+func ParseObjectName(name string) (time.Time, error) {
+	return defaultLayout.ParseObjectName(name)
+}
@@ -0,0 +1,87 @@
+package gharchive
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestObjectName(t *testing.T) {
+	require.Equal(t, "2020-10-10-8.json.gz", ObjectName(time.Date(2020, 10, 10, 8, 30, 0, 0, time.UTC)))
+	require.Equal(t, "2020-10-10-18.json.gz", ObjectName(time.Date(2020, 10, 10, 18, 0, 0, 0, time.UTC)))
+	require.Equal(t, "2020-10-10-0.json.gz", ObjectName(time.Date(2020, 10, 9, 19, 0, 0, 0, time.FixedZone("", -5*3600))))
+}
+
+func TestParseObjectName(t *testing.T) {
+	got, err := ParseObjectName("2020-10-10-8.json.gz")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC), got)
+	for _, name := range []string{
+		"2020-10-10-08.json.gz",
+		"2020-10-10-24.json.gz",
+		"2020-10-10--1.json.gz",
+		"2020-10-10-+1.json.gz",
+		"2020-10-10-8.json",
+		"2020-10-10.json.gz",
+		"2020-13-10-8.json.gz",
+		"",
+	} {
+		_, err = ParseObjectName(name)
+		require.Error(t, err, name)
+	}
+}
+
+func TestDateLayout_roundTrip(t *testing.T) {
+	for _, layout := range []DateLayout{
+		{},
+		{Daily: true},
+		{Ext: ".json.zst"},
+		{Daily: true, Ext: ".ndjson"},
+	} {
+		tm := time.Date(2011, 2, 12, 0, 17, 0, 0, time.UTC)
+		for i := 0; i < 24*400; i++ {
+			tm = tm.Add(time.Hour + 7*time.Minute)
+			name := layout.ObjectName(tm)
+			start, err := layout.ParseObjectName(name)
+			require.NoError(t, err, name)
+			require.Equal(t, tm.Truncate(layout.Period()), start)
+			require.Equal(t, name, layout.ObjectName(start))
+		}
+	}
+	_, err := DateLayout{Daily: true}.ParseObjectName("2020-10-10-8.json.gz")
+	require.Error(t, err)
+	_, err = DateLayout{}.ParseObjectName("2020-10-10-8.json.zst")
+	require.Error(t, err)
+}
+
+func TestOptions_Layout(t *testing.T) {
+	var opts *Options
+	require.Equal(t, DateLayout{}, opts.Layout())
+	require.Equal(t, DateLayout{}, new(Options).Layout())
+	daily := DateLayout{Daily: true}
+	require.Equal(t, daily, (&Options{ObjectLayout: daily}).Layout())
+}
+
+func TestScanner_ObjectLayout(t *testing.T) {
+	day := time.Date(2020, 10, 10, 0, 0, 0, 0, time.UTC)
+	layout := DateLayout{Daily: true}
+	src := NewDirSource(t.TempDir())
+	var want []string
+	for i := 0; i < 2; i++ {
+		lines := testEventLines(day.AddDate(0, 0, i), 50)
+		writeObject(t, src, layout.ObjectName(day.AddDate(0, 0, i)), gzipLines(t, lines))
+		want = append(want, lineStrings(lines)...)
+	}
+	for _, opts := range []*Options{
+		{PreserveOrder: true},
+		{Concurrency: 2},
+	} {
+		opts.Source = src
+		opts.EndTime = day.AddDate(0, 0, 2)
+		opts.ObjectLayout = layout
+		opts.Validators = []Validator{ValidateNotEmpty()}
+		got := scanLines(t, day.Add(5*time.Hour), opts)
+		require.ElementsMatch(t, want, got)
+	}
+}
@@ -0,0 +1,82 @@
+package gharchive
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Progress reports on a scan after an hour has been read.
+type Progress struct {
+	Hour              time.Time // the hour that was just read
+	HoursCompleted    int       // number of hours read so far, including Hour
+	HoursTotal        int       // number of hours in the scan
+	BytesDownloaded   int64     // compressed bytes downloaded for Hour
+	BytesDecompressed int64     // bytes decompressed for Hour
+	LinesEmitted      int64     // lines from Hour that passed the time range and validators
+	LinesFiltered     int64     // lines from Hour that were dropped by the time range or validators
+	Skipped           bool      // Hour wasn't read because its index shows that it can't match Options.IndexFilter or because Options.StrictTimeRange stopped the scan at an earlier hour
+}
+
+// progressReporter counts completed hours and calls an Options.Progress func one call at a time.
+type progressReporter struct {
+	fn        func(Progress)
+	total     int
+	mux       sync.Mutex
+	completed int
+}
+
+func newProgressReporter(fn func(Progress), total int) *progressReporter {
+	if fn == nil {
+		return nil
+	}
+	return &progressReporter{
+		fn:    fn,
+		total: total,
+	}
+}
+
+// report fills in the hour counts on p and passes it to fn. It does nothing when r is nil.
+func (r *progressReporter) report(p Progress) {
+	if r == nil {
+		return
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.completed++
+	p.HoursCompleted = r.completed
+	p.HoursTotal = r.total
+	r.fn(p)
+}
+
+// hourCount returns the number of objects covering period from the one containing start up to end.
+func hourCount(start, end time.Time, period time.Duration) int {
+	count := 0
+	for hour := start.Truncate(period); hour.Before(end); hour = hour.Add(period) {
+		count++
+	}
+	return count
+}
+
+// countingReader counts the bytes read from r and keeps the first error other than io.EOF.
+type countingReader struct {
+	r     io.Reader
+	count int64
+	err   error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.count += int64(n)
+	if err != nil && err != io.EOF && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
+
+func (c *countingReader) Close() error {
+	if closer, ok := c.r.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
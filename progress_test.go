@@ -0,0 +1,51 @@
+package gharchive
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanner_Progress(t *testing.T) {
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	end := start.Add(4 * time.Hour)
+	src := writeEventHours(t, start, end, 1000)
+	var hourSize int64
+	for _, line := range testEventLines(start, 1000) {
+		hourSize += int64(len(line))
+	}
+	for _, concurrency := range []int{1, 3} {
+		var got []Progress
+		lines := scanLines(t, start, &Options{
+			Source:      src,
+			EndTime:     end,
+			Concurrency: concurrency,
+			Validators: []Validator{
+				ValidateNotEmpty(),
+				ValidateSample(0.5, 0),
+			},
+			Progress: func(p Progress) {
+				got = append(got, p)
+			},
+		})
+		require.Len(t, got, 4)
+		var emitted int64
+		hours := map[time.Time]bool{}
+		for i, p := range got {
+			require.Equal(t, i+1, p.HoursCompleted)
+			require.Equal(t, 4, p.HoursTotal)
+			require.Equal(t, int64(1000), p.LinesEmitted+p.LinesFiltered)
+			require.Greater(t, p.BytesDownloaded, int64(0))
+			require.Less(t, p.BytesDownloaded, p.BytesDecompressed)
+			emitted += p.LinesEmitted
+			hours[p.Hour] = true
+		}
+		require.Len(t, hours, 4)
+		require.Equal(t, int64(len(lines)), emitted)
+		if concurrency == 1 {
+			require.Equal(t, start, got[0].Hour)
+			require.Equal(t, hourSize, got[0].BytesDecompressed)
+		}
+	}
+}
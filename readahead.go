@@ -0,0 +1,125 @@
+package gharchive
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// tuning constants for readAhead.
+const (
+	readAheadBufSize = 256 * 1024
+	readAheadDepth   = 8
+)
+
+var readAheadPool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, readAheadBufSize)
+	},
+}
+
+// errReadAheadClosed is returned by a Read that was waiting for data when its readAhead was closed.
+var errReadAheadClosed = errors.New("read ahead closed")
+
+// readFunc is an io.Reader for a function.
+type readFunc func(p []byte) (int, error)
+
+func (f readFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
+
+// readChunk is data read by a readAhead and the error that ended the read.
+type readChunk struct {
+	buf  []byte
+	data []byte
+	err  error
+}
+
+// readAhead reads from r on its own goroutine into a queue of buffers. Errors are returned after the data that was
+// read before them. errCorruptionSkipped doesn't end reading.
+type readAhead struct {
+	r         io.Reader
+	chunks    chan *readChunk
+	done      chan struct{}
+	exited    chan struct{}
+	closeOnce sync.Once
+	closeErr  error // returned by every call to Close
+	cur       *readChunk
+	err       error
+}
+
+func newReadAhead(r io.Reader) *readAhead {
+	ra := &readAhead{
+		r:      r,
+		chunks: make(chan *readChunk, readAheadDepth),
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	go ra.run(r)
+	return ra
+}
+
+func (ra *readAhead) run(r io.Reader) {
+	defer close(ra.exited)
+	for {
+		buf := readAheadPool.Get().([]byte)
+		chunk := &readChunk{buf: buf}
+		n := 0
+		for n < len(buf) && chunk.err == nil {
+			var m int
+			m, chunk.err = r.Read(buf[n:])
+			n += m
+		}
+		chunk.data = buf[:n]
+		select {
+		case ra.chunks <- chunk:
+		case <-ra.done:
+			return
+		}
+		if chunk.err != nil && chunk.err != errCorruptionSkipped {
+			return
+		}
+	}
+}
+
+// Read returns data read by the goroutine. It returns errReadAheadClosed once Close is called.
+func (ra *readAhead) Read(p []byte) (int, error) {
+	for ra.cur == nil || len(ra.cur.data) == 0 {
+		if ra.err != nil {
+			return 0, ra.err
+		}
+		if ra.cur != nil {
+			err := ra.cur.err
+			readAheadPool.Put(ra.cur.buf) //nolint:staticcheck // the slice header allocation is fine
+			ra.cur = nil
+			if err == errCorruptionSkipped {
+				return 0, err
+			}
+			if err != nil {
+				ra.err = err
+				return 0, err
+			}
+		}
+		select {
+		case ra.cur = <-ra.chunks:
+		case <-ra.done:
+			ra.err = errReadAheadClosed
+			return 0, ra.err
+		}
+	}
+	n := copy(p, ra.cur.data)
+	ra.cur.data = ra.cur.data[n:]
+	return n, nil
+}
+
+// Close closes r if r is an io.Closer so that a read in progress returns, then stops the goroutine and waits for it.
+func (ra *readAhead) Close() error {
+	ra.closeOnce.Do(func() {
+		if closer, ok := ra.r.(io.Closer); ok {
+			ra.closeErr = closer.Close()
+		}
+		close(ra.done)
+		<-ra.exited
+	})
+	return ra.closeErr
+}
@@ -0,0 +1,133 @@
+package gharchive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_readAhead(t *testing.T) {
+	t.Run("data before error", func(t *testing.T) {
+		errRead := errors.New("read failed")
+		ra := newReadAhead(io.MultiReader(strings.NewReader("foo\nbar\n"), iotest.ErrReader(errRead)))
+		defer func() { require.NoError(t, ra.Close()) }()
+		got, err := io.ReadAll(ra)
+		require.Equal(t, "foo\nbar\n", string(got))
+		require.Equal(t, errRead, err)
+	})
+
+	t.Run("close during a read", func(t *testing.T) {
+		pr, pw := io.Pipe()
+		defer func() { require.NoError(t, pw.Close()) }()
+		ra := newReadAhead(pr)
+		readErr := make(chan error)
+		go func() {
+			_, err := ra.Read(make([]byte, 10))
+			readErr <- err
+		}()
+		closed := make(chan error)
+		go func() {
+			closed <- ra.Close()
+		}()
+		select {
+		case err := <-closed:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Close is waiting for the read")
+		}
+		require.Error(t, <-readErr)
+		_, err := ra.Read(make([]byte, 10))
+		require.Error(t, err)
+	})
+}
+
+func TestScanner_DecodeConcurrency(t *testing.T) {
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	end := start.Add(3 * time.Hour)
+	src := writeEventHours(t, start, end, 3000)
+	// a zstd hour with many frames
+	zstdHour := end
+	lines := testEventLines(zstdHour, 3000)
+	enc, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	var data []byte
+	for i := 0; i < len(lines); i += 100 {
+		data = enc.EncodeAll(bytes.Join(lines[i:i+100], nil), data)
+	}
+	require.NoError(t, enc.Close())
+	zstdName, ok := ZstdName(ObjectName(zstdHour))
+	require.True(t, ok)
+	writeObject(t, src, zstdName, data)
+	for _, opts := range []*Options{
+		{PreserveOrder: true},
+		{SingleHour: true},
+		{Concurrency: 2},
+	} {
+		scanStart := start
+		if opts.SingleHour {
+			scanStart = zstdHour
+		}
+		opts.Source = src
+		opts.EndTime = end.Add(time.Hour)
+		want := scanLines(t, scanStart, opts)
+		opts.DecodeConcurrency = 4
+		got := scanLines(t, scanStart, opts)
+		if opts.Concurrency > 1 {
+			require.ElementsMatch(t, want, got)
+		} else {
+			require.Equal(t, want, got)
+		}
+		require.NotEmpty(t, got)
+	}
+}
+
+// slowSource is a Source that sleeps before each read so decoding waits on downloads.
+type slowSource struct {
+	Source
+}
+
+func (s slowSource) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	rdr, err := s.Source.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: readFunc(func(p []byte) (int, error) {
+			time.Sleep(time.Millisecond)
+			return rdr.Read(p)
+		}),
+		Closer: rdr,
+	}, nil
+}
+
+func TestScanner_DecodeConcurrency_closeEarly(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	end := start.Add(4 * time.Hour)
+	src := slowSource{Source: writeEventHours(t, start, end, 20_000)}
+	for _, opts := range []*Options{
+		{PreserveOrder: true},
+		{Concurrency: 4},
+	} {
+		opts.Source = src
+		opts.EndTime = end
+		opts.DecodeConcurrency = 4
+		scanner, err := New(ctx, start, opts)
+		require.NoError(t, err)
+		for i := 0; i < 100; i++ {
+			require.True(t, scanner.Scan(ctx))
+		}
+		require.NoError(t, scanner.Close())
+	}
+}
@@ -0,0 +1,90 @@
+package gharchive
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// ValidateSample keeps about rate (between 0 and 1) of all lines. Whether a line is kept depends only on its
+// content and seed, so the same lines are kept across runs. Use a different seed to get a different sample.
+func ValidateSample(rate float64, seed uint64) Validator {
+	return func(line []byte) bool {
+		return sampled(line, rate, seed)
+	}
+}
+
+// ValidateSampleByField keeps lines for about rate (between 0 and 1) of the distinct values of field. field is a
+// dot-separated path to a value in a json object like "repo.name". Lines with the same value are either all kept or
+// all dropped, so ValidateSampleByField("repo.name", 0.01, 0) keeps every event for 1% of repos. Lines that don't
+// have field are dropped.
+func ValidateSampleByField(field string, rate float64, seed uint64) Validator {
+	path := strings.Split(field, ".")
+	return func(line []byte) bool {
+		val, ok := jsonPathValue(line, path)
+		if !ok {
+			return false
+		}
+		return sampled(val, rate, seed)
+	}
+}
+
+// sampled hashes data with seed and returns true when the hash falls in the first rate of the hash space.
+func sampled(data []byte, rate float64, seed uint64) bool {
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+	var seedBytes [8]byte
+	binary.LittleEndian.PutUint64(seedBytes[:], seed)
+	h := fnv.New64a()
+	_, _ = h.Write(seedBytes[:]) //nolint:errcheck // hash.Hash never returns an error
+	_, _ = h.Write(data)         //nolint:errcheck // hash.Hash never returns an error
+	// use the top 53 bits so the result converts to a float64 exactly
+	return float64(mix64(h.Sum64())>>11)/(1<<53) < rate
+}
+
+// jsonPathValue returns the value at path in a json object. Strings are returned without quotes. Other values are
+// returned as raw json. It returns false for an empty path.
+func jsonPathValue(line []byte, path []string) ([]byte, bool) {
+	if len(path) == 0 {
+		return nil, false
+	}
+	iter := jsoniter.ConfigFastest.BorrowIterator(line)
+	defer jsoniter.ConfigFastest.ReturnIterator(iter)
+	var val []byte
+	var found bool
+	var readPath func(depth int)
+	readPath = func(depth int) {
+		iter.ReadObjectCB(func(iter *jsoniter.Iterator, field string) bool {
+			if field != path[depth] {
+				iter.Skip()
+				return true
+			}
+			switch {
+			case depth < len(path)-1:
+				if iter.WhatIsNext() != jsoniter.ObjectValue {
+					iter.Skip()
+					return false
+				}
+				readPath(depth + 1)
+			case iter.WhatIsNext() == jsoniter.StringValue:
+				val = append(val, iter.ReadString()...)
+				found = true
+			default:
+				val = append(val, iter.SkipAndReturnBytes()...)
+				found = true
+			}
+			return false
+		})
+	}
+	readPath(0)
+	if iter.Error != nil && !found {
+		return nil, false
+	}
+	return val, found
+}
@@ -0,0 +1,73 @@
+package gharchive
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSample(t *testing.T) {
+	validator := ValidateSample(0.1, 1)
+	var kept []string
+	for i := 0; i < 10_000; i++ {
+		line := fmt.Sprintf(`{"id":"%d"}`, i)
+		if validator([]byte(line)) {
+			kept = append(kept, line)
+		}
+	}
+	require.InDelta(t, 1000, len(kept), 100)
+	for _, line := range kept {
+		require.True(t, validator([]byte(line)))
+	}
+	var overlap int
+	otherSeed := ValidateSample(0.1, 2)
+	for _, line := range kept {
+		if otherSeed([]byte(line)) {
+			overlap++
+		}
+	}
+	require.Less(t, overlap, 200)
+	require.True(t, ValidateSample(1, 0)([]byte("x")))
+	require.False(t, ValidateSample(0, 0)([]byte("x")))
+}
+
+func TestValidateSampleByField(t *testing.T) {
+	validator := ValidateSampleByField("repo.name", 0.05, 0)
+	keptRepos := map[int]bool{}
+	for i := 0; i < 2000; i++ {
+		line := fmt.Sprintf(`{"id":"%d","repo":{"id":%d,"name":"o/r%d"}}`, i, i, i)
+		if validator([]byte(line)) {
+			keptRepos[i] = true
+		}
+	}
+	require.InDelta(t, 100, len(keptRepos), 40)
+	for i := 0; i < 2000; i++ {
+		// different events for the same repo get the same decision
+		line := fmt.Sprintf(`{"type":"PushEvent","repo":{"url":"x","name":"o/r%d","id":0},"id":"x%d"}`, i, i)
+		require.Equal(t, keptRepos[i], validator([]byte(line)))
+	}
+	require.False(t, ValidateSampleByField("repo.name", 1, 0)([]byte(`{"repo":{"id":1}}`)))
+	require.False(t, ValidateSampleByField("repo.name", 1, 0)([]byte(`{"repo":"o/r"}`)))
+	require.True(t, ValidateSampleByField("repo.id", 1, 0)([]byte(`{"repo":{"id":1}}`)))
+}
+
+func Test_jsonPathValue(t *testing.T) {
+	line := []byte(`{"a":{"b":"x\"y","c":{"d":[1, 2]}},"e":12}`)
+	for _, td := range []struct {
+		path []string
+		want string
+		ok   bool
+	}{
+		{path: []string{"a", "b"}, want: `x"y`, ok: true},
+		{path: []string{"a", "c", "d"}, want: `[1, 2]`, ok: true},
+		{path: []string{"e"}, want: `12`, ok: true},
+		{path: []string{"a", "x"}},
+		{path: []string{"e", "x"}},
+		{path: nil},
+	} {
+		got, ok := jsonPathValue(line, td.path)
+		require.Equal(t, td.ok, ok, td.path)
+		require.Equal(t, td.want, string(got), td.path)
+	}
+}
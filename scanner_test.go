@@ -0,0 +1,148 @@
+package gharchive_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/willabides/gharchive-client"
+	"github.com/willabides/gharchive-client/gharchivegen"
+	"github.com/willabides/gharchive-client/gharchivetest"
+)
+
+// setupGeneratedServer starts a gharchivetest.Server with generated hours from start up to end.
+func setupGeneratedServer(ctx context.Context, t testing.TB, start, end time.Time, genOpts *gharchivegen.Options) *gharchivetest.Server {
+	t.Helper()
+	server := gharchivetest.NewServer(nil)
+	t.Cleanup(server.Close)
+	gen, err := gharchivegen.New(genOpts)
+	require.NoError(t, err)
+	for hour := start; hour.Before(end); hour = hour.Add(time.Hour) {
+		require.NoError(t, server.SetHourLines(hour, gen.HourLines(hour)))
+	}
+	return server
+}
+
+func scanLines(ctx context.Context, t testing.TB, server *gharchivetest.Server, start time.Time, opts *gharchive.Options) []string {
+	t.Helper()
+	client, err := server.Client(ctx)
+	require.NoError(t, err)
+	opts.StorageClient = client
+	scanner, err := gharchive.New(ctx, start, opts)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, scanner.Close())
+	})
+	var got []string
+	for scanner.Scan(ctx) {
+		got = append(got, string(scanner.Bytes()))
+	}
+	require.NoError(t, scanner.Err())
+	return got
+}
+
+func TestScanner_concurrentMatchesSingle(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	end := start.Add(6 * time.Hour)
+	for _, genOpts := range []*gharchivegen.Options{
+		{EventsPerHour: 3000, PayloadSize: 50},
+		// lines bigger than a batch buffer
+		{EventsPerHour: 5, PayloadSize: 400 * 1024},
+	} {
+		server := setupGeneratedServer(ctx, t, start, end, genOpts)
+		want := scanLines(ctx, t, server, start, &gharchive.Options{
+			EndTime:       end,
+			PreserveOrder: true,
+		})
+		got := scanLines(ctx, t, server, start, &gharchive.Options{
+			EndTime:     end,
+			Concurrency: 4,
+		})
+		sort.Strings(want)
+		sort.Strings(got)
+		require.Equal(t, want, got)
+	}
+}
+
+func TestScanner_NextBatch(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	end := start.Add(3 * time.Hour)
+	server := setupGeneratedServer(ctx, t, start, end, &gharchivegen.Options{
+		EventsPerHour: 2500,
+	})
+	want := scanLines(ctx, t, server, start, &gharchive.Options{
+		EndTime:       end,
+		PreserveOrder: true,
+	})
+	for _, concurrency := range []int{1, 3} {
+		client, err := server.Client(ctx)
+		require.NoError(t, err)
+		scanner, err := gharchive.New(ctx, start, &gharchive.Options{
+			StorageClient: client,
+			EndTime:       end,
+			Concurrency:   concurrency,
+		})
+		require.NoError(t, err)
+		var got []string
+		// mix Scan and NextBatch to check that no lines are skipped or repeated
+		for i := 0; i < 10 && scanner.Scan(ctx); i++ {
+			got = append(got, string(scanner.Bytes()))
+		}
+		for batch := scanner.NextBatch(ctx); batch != nil; batch = scanner.NextBatch(ctx) {
+			for _, line := range batch {
+				got = append(got, string(line))
+			}
+		}
+		require.NoError(t, scanner.Err())
+		require.NoError(t, scanner.Close())
+		if concurrency == 1 {
+			require.Equal(t, want, got)
+			continue
+		}
+		wantSorted := append([]string{}, want...)
+		sort.Strings(wantSorted)
+		sort.Strings(got)
+		require.Equal(t, wantSorted, got)
+	}
+}
+
+func TestScanner_MaxBufferedBytes(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	end := start.Add(8 * time.Hour)
+	server := setupGeneratedServer(ctx, t, start, end, &gharchivegen.Options{
+		EventsPerHour: 5000,
+	})
+	client, err := server.Client(ctx)
+	require.NoError(t, err)
+	maxBuffered := int64(1024 * 1024)
+	scanner, err := gharchive.New(ctx, start, &gharchive.Options{
+		StorageClient:    client,
+		EndTime:          end,
+		Concurrency:      8,
+		MaxBufferedBytes: maxBuffered,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, scanner.Close())
+	})
+	var count int
+	var sawBuffered bool
+	for batch := scanner.NextBatch(ctx); batch != nil; batch = scanner.NextBatch(ctx) {
+		// give workers a chance to fill the buffer while the consumer is slow
+		time.Sleep(time.Millisecond)
+		buffered := scanner.BufferedBytes()
+		require.LessOrEqual(t, buffered, maxBuffered)
+		if buffered > 0 {
+			sawBuffered = true
+		}
+		count += len(batch)
+	}
+	require.NoError(t, scanner.Err())
+	require.True(t, sawBuffered)
+	require.Equal(t, 8*5000, count)
+}
@@ -0,0 +1,324 @@
+package gharchive
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// SeekIndexExt is the extension of seek index files. An hour's seek index file is named by SeekIndexName.
+const SeekIndexExt = ".seek"
+
+// SeekIndexName returns the name of the seek index file for an object like 2020-10-01-5.seek for
+// 2020-10-01-5.json.gz.
+func SeekIndexName(objectName string) string {
+	return indexFileName(objectName, SeekIndexExt)
+}
+
+// seekCheckpointInterval is roughly how many decompressed bytes are between checkpoints that aren't at restart points.
+const seekCheckpointInterval = 1 << 20
+
+// seekIndexMagic starts an encoded SeekIndex. The last byte is the format version.
+var seekIndexMagic = []byte("GHASEEK\x01")
+
+// SeekIndex records lines in an object where a scan can start reading. Decompression can only start at a gzip member
+// or zstd frame, so there is a checkpoint at the first line in each one. Other checkpoints only save splitting and
+// validating lines. "gharchive recompress" writes a zstd frame every few MB.
+type SeekIndex struct {
+	Object      string // the object the index was built from. this is the ZstdName variant for zstd content.
+	Checkpoints []SeekCheckpoint
+}
+
+// SeekCheckpoint is a line in an object where a scan can start reading.
+type SeekCheckpoint struct {
+	Offset             int64     // offset of the gzip member or zstd frame to start decompressing from
+	SkipBytes          int64     // decompressed bytes from Offset to discard to reach the line
+	Line               int64     // number of lines before the line
+	DecompressedOffset int64     // offset of the line in the decompressed object
+	MaxCreatedAt       time.Time // latest created_at from the line up to the next checkpoint. zero when there isn't one
+}
+
+// checkpointFor returns the first checkpoint that may have events created at or after tm. Earlier lines have no such
+// events. A checkpoint without a MaxCreatedAt may have them. It returns the last checkpoint when none has them and
+// false when there are no checkpoints.
+func (x *SeekIndex) checkpointFor(tm time.Time) (SeekCheckpoint, bool) {
+	if len(x.Checkpoints) == 0 {
+		return SeekCheckpoint{}, false
+	}
+	for _, cp := range x.Checkpoints {
+		if cp.MaxCreatedAt.IsZero() || !cp.MaxCreatedAt.Before(tm) {
+			return cp, true
+		}
+	}
+	return x.Checkpoints[len(x.Checkpoints)-1], true
+}
+
+// seekIndexBuilder makes checkpoints from decompressed data written to it and the restart points between.
+type seekIndexBuilder struct {
+	index               *SeekIndex
+	restartOffset       int64 // compressed offset of the last restart point
+	restartDecompressed int64 // decompressed offset of the last restart point
+	restartPending      bool  // the next line gets a checkpoint because there is a new restart point
+	decompressed        int64
+	lines               int64
+	midLine             bool
+	line                []byte // the current line up to what has been written
+}
+
+// restart records a gzip member or zstd frame at offset in the compressed object.
+func (b *seekIndexBuilder) restart(offset int64) {
+	b.restartOffset = offset
+	b.restartDecompressed = b.decompressed
+	b.restartPending = true
+}
+
+func (b *seekIndexBuilder) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if !b.midLine {
+			b.startLine()
+		}
+		i := bytes.IndexByte(p, '\n')
+		if i == -1 {
+			b.line = append(b.line, p...)
+			b.decompressed += int64(len(p))
+			break
+		}
+		b.line = append(b.line, p[:i+1]...)
+		b.decompressed += int64(i + 1)
+		p = p[i+1:]
+		b.endLine()
+	}
+	return n, nil
+}
+
+func (b *seekIndexBuilder) startLine() {
+	b.midLine = true
+	checkpoints := b.index.Checkpoints
+	if !b.restartPending && len(checkpoints) > 0 &&
+		b.decompressed-checkpoints[len(checkpoints)-1].DecompressedOffset < seekCheckpointInterval {
+		return
+	}
+	b.restartPending = false
+	b.index.Checkpoints = append(b.index.Checkpoints, SeekCheckpoint{
+		Offset:             b.restartOffset,
+		SkipBytes:          b.decompressed - b.restartDecompressed,
+		Line:               b.lines,
+		DecompressedOffset: b.decompressed,
+	})
+}
+
+func (b *seekIndexBuilder) endLine() {
+	cur := &b.index.Checkpoints[len(b.index.Checkpoints)-1]
+	createdAt, ok := eventCreatedAt(b.line)
+	if ok && createdAt.After(cur.MaxCreatedAt) {
+		cur.MaxCreatedAt = createdAt.UTC()
+	}
+	b.lines++
+	b.midLine = false
+	b.line = b.line[:0]
+}
+
+// BuildSeekIndex reads the raw content of the object named name from r and returns its seek index. Index.Object is
+// ZstdName(name) when the content is zstd.
+func BuildSeekIndex(r io.Reader, name string) (*SeekIndex, error) {
+	counted := &countingReader{r: r}
+	br := bufio.NewReaderSize(counted, 32*1024)
+	offset := func() int64 {
+		return counted.count - int64(br.Buffered())
+	}
+	b := &seekIndexBuilder{
+		index: &SeekIndex{Object: name},
+	}
+	magic, _ := br.Peek(len(zstdMagic)) //nolint:errcheck // the decompressor gets the same error
+	var err error
+	if bytes.Equal(magic, zstdMagic) {
+		if zstdName, ok := ZstdName(name); ok {
+			b.index.Object = zstdName
+		}
+		err = b.readZstd(br, offset)
+	} else {
+		err = b.readGzip(br, offset)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if b.midLine {
+		b.endLine()
+	}
+	return b.index, nil
+}
+
+// readGzip decompresses one gzip member at a time from br so that each one is a restart point.
+func (b *seekIndexBuilder) readGzip(br *bufio.Reader, offset func() int64) error {
+	b.restart(offset())
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		return err
+	}
+	for {
+		gz.Multistream(false)
+		_, err = io.Copy(b, gz)
+		if err != nil {
+			return err
+		}
+		_, err = br.Peek(1)
+		if err == io.EOF {
+			return gz.Close()
+		}
+		if err != nil {
+			return err
+		}
+		b.restart(offset())
+		err = gz.Reset(br)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readZstd decompresses one zstd frame at a time from br so that each one is a restart point. Once it reaches a frame
+// that is too big to decompress whole, it streams the rest of br without more restart points.
+func (b *seekIndexBuilder) readZstd(br *bufio.Reader, offset func() int64) error {
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxZstdFrameSize))
+	if err != nil {
+		return err
+	}
+	defer dec.Close()
+	var compressed, data []byte
+	for {
+		_, err = br.Peek(1)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		b.restart(offset())
+		size, ok := peekZstdFrameSize(br)
+		if !ok || size > maxZstdFrameSize {
+			err = dec.Reset(br)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(b, dec)
+			return err
+		}
+		compressed, err = readZstdFrame(br, compressed[:0])
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		data, err = dec.DecodeAll(compressed, data[:0])
+		if err != nil {
+			return err
+		}
+		_, _ = b.Write(data) //nolint:errcheck // never fails
+	}
+}
+
+// WriteTo writes x in the format that ReadSeekIndex reads.
+func (x *SeekIndex) WriteTo(w io.Writer) (int64, error) {
+	buf := append([]byte{}, seekIndexMagic...)
+	buf = binary.AppendUvarint(buf, uint64(len(x.Object)))
+	buf = append(buf, x.Object...)
+	buf = binary.AppendUvarint(buf, uint64(len(x.Checkpoints)))
+	for _, cp := range x.Checkpoints {
+		buf = binary.AppendUvarint(buf, uint64(cp.Offset))
+		buf = binary.AppendUvarint(buf, uint64(cp.SkipBytes))
+		buf = binary.AppendUvarint(buf, uint64(cp.Line))
+		buf = binary.AppendUvarint(buf, uint64(cp.DecompressedOffset))
+		var createdAt int64
+		if !cp.MaxCreatedAt.IsZero() {
+			createdAt = cp.MaxCreatedAt.Unix()
+		}
+		buf = binary.AppendVarint(buf, createdAt)
+	}
+	n, err := w.Write(appendIndexChecksum(buf))
+	return int64(n), err
+}
+
+// ReadSeekIndex reads a SeekIndex written by SeekIndex.WriteTo.
+func ReadSeekIndex(r io.Reader) (*SeekIndex, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	body, err := checkIndexData(data, seekIndexMagic)
+	if err != nil {
+		return nil, err
+	}
+	br := bytes.NewReader(body)
+	// readUvarints reads uvarints into vals and returns false when it can't
+	readUvarints := func(vals ...*uint64) bool {
+		for _, val := range vals {
+			var readErr error
+			*val, readErr = binary.ReadUvarint(br)
+			if readErr != nil {
+				return false
+			}
+		}
+		return true
+	}
+	var nameLen, count uint64
+	if !readUvarints(&nameLen) || nameLen > uint64(br.Len()) {
+		return nil, ErrInvalidIndex
+	}
+	name := make([]byte, nameLen)
+	_, err = io.ReadFull(br, name)
+	if err != nil || !readUvarints(&count) || count > uint64(br.Len()) {
+		return nil, ErrInvalidIndex
+	}
+	x := &SeekIndex{
+		Object:      string(name),
+		Checkpoints: make([]SeekCheckpoint, count),
+	}
+	for i := range x.Checkpoints {
+		var offset, skip, line, decompressed uint64
+		if !readUvarints(&offset, &skip, &line, &decompressed) {
+			return nil, ErrInvalidIndex
+		}
+		createdAt, err := binary.ReadVarint(br)
+		if err != nil {
+			return nil, ErrInvalidIndex
+		}
+		cp := SeekCheckpoint{
+			Offset:             int64(offset),
+			SkipBytes:          int64(skip),
+			Line:               int64(line),
+			DecompressedOffset: int64(decompressed),
+		}
+		if createdAt != 0 {
+			cp.MaxCreatedAt = time.Unix(createdAt, 0).UTC()
+		}
+		x.Checkpoints[i] = cp
+	}
+	if br.Len() > 0 {
+		return nil, ErrInvalidIndex
+	}
+	return x, nil
+}
+
+// readSeekIndex reads the seek index for the object named name from src. It returns nil without an error when there
+// isn't one.
+func readSeekIndex(ctx context.Context, src Source, name string) (*SeekIndex, error) {
+	rdr, err := src.Open(ctx, SeekIndexName(name))
+	if err == ErrObjectNotExist {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rdr.Close() //nolint:errcheck // read only
+	}()
+	return ReadSeekIndex(rdr)
+}
@@ -0,0 +1,252 @@
+package gharchive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildSeekIndex(t *testing.T) {
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	var content []byte
+	for i := 0; i < 3000; i++ {
+		createdAt := start.Add(time.Duration(i) * time.Second)
+		content = append(content, fmt.Sprintf(`{"id":"%d","payload":%q,"created_at":%q}`+"\n",
+			i, bytes.Repeat([]byte("x"), i%700), createdAt.Format(time.RFC3339))...)
+	}
+	// chunks splits content into pieces of size bytes without regard to lines
+	chunks := func(size int) [][]byte {
+		var out [][]byte
+		for data := content; len(data) > 0; {
+			n := size
+			if n > len(data) {
+				n = len(data)
+			}
+			out = append(out, data[:n])
+			data = data[n:]
+		}
+		return out
+	}
+	gzipMembers := func(chunks [][]byte) []byte {
+		var out []byte
+		for _, chunk := range chunks {
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			_, err := gz.Write(chunk)
+			require.NoError(t, err)
+			require.NoError(t, gz.Close())
+			out = append(out, buf.Bytes()...)
+		}
+		return out
+	}
+	enc, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	zstdFrames := func(chunks [][]byte) []byte {
+		var out []byte
+		for _, chunk := range chunks {
+			out = enc.EncodeAll(chunk, out)
+		}
+		return out
+	}
+	// decompressFrom decompresses data from offset to the end
+	decompressFrom := func(t *testing.T, data []byte, offset int64) []byte {
+		t.Helper()
+		var rdr io.Reader
+		if bytes.HasPrefix(data, zstdMagic) {
+			dec, err := zstd.NewReader(bytes.NewReader(data[offset:]))
+			require.NoError(t, err)
+			defer dec.Close()
+			rdr = dec
+		} else {
+			gz, err := gzip.NewReader(bytes.NewReader(data[offset:]))
+			require.NoError(t, err)
+			rdr = gz
+		}
+		got, err := io.ReadAll(rdr)
+		require.NoError(t, err)
+		return got
+	}
+
+	for _, td := range []struct {
+		name     string
+		data     []byte
+		object   string
+		restarts int
+	}{
+		{
+			name:     "single gzip member",
+			data:     gzipMembers([][]byte{content}),
+			object:   "2020-10-10-8.json.gz",
+			restarts: 1,
+		},
+		{
+			name:     "gzip members split mid-line",
+			data:     gzipMembers(chunks(100_000)),
+			object:   "2020-10-10-8.json.gz",
+			restarts: len(chunks(100_000)),
+		},
+		{
+			name:     "zstd frames split mid-line",
+			data:     zstdFrames(chunks(100_000)),
+			object:   "2020-10-10-8.json.zst",
+			restarts: len(chunks(100_000)),
+		},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			index, err := BuildSeekIndex(bytes.NewReader(td.data), "2020-10-10-8.json.gz")
+			require.NoError(t, err)
+			require.Equal(t, td.object, index.Object)
+			offsets := map[int64]bool{}
+			for i, cp := range index.Checkpoints {
+				offsets[cp.Offset] = true
+				require.Equal(t, content[cp.DecompressedOffset:], decompressFrom(t, td.data, cp.Offset)[cp.SkipBytes:])
+				require.Equal(t, int64(bytes.Count(content[:cp.DecompressedOffset], []byte("\n"))), cp.Line)
+				require.True(t, cp.DecompressedOffset == 0 || content[cp.DecompressedOffset-1] == '\n')
+				end := int64(len(content))
+				if i+1 < len(index.Checkpoints) {
+					end = index.Checkpoints[i+1].DecompressedOffset
+					require.Less(t, end-cp.DecompressedOffset, int64(seekCheckpointInterval+1000))
+				}
+				lastLine := bytes.Count(content[:end], []byte("\n")) - 1
+				require.Equal(t, start.Add(time.Duration(lastLine)*time.Second), cp.MaxCreatedAt)
+			}
+			require.Len(t, offsets, td.restarts)
+
+			var buf bytes.Buffer
+			_, err = index.WriteTo(&buf)
+			require.NoError(t, err)
+			got, err := ReadSeekIndex(bytes.NewReader(buf.Bytes()))
+			require.NoError(t, err)
+			require.Equal(t, index, got)
+			_, err = ReadSeekIndex(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+			require.True(t, errors.Is(err, ErrInvalidIndex))
+
+			cp, ok := index.checkpointFor(start.Add(45 * time.Minute))
+			require.True(t, ok)
+			require.Greater(t, cp.Line, int64(0))
+			require.LessOrEqual(t, cp.Line, int64(45*60))
+			cp, ok = index.checkpointFor(start.Add(2 * time.Hour))
+			require.True(t, ok)
+			require.Equal(t, index.Checkpoints[len(index.Checkpoints)-1], cp)
+		})
+	}
+}
+
+func TestSeekIndex_checkpointFor(t *testing.T) {
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	index := &SeekIndex{
+		Checkpoints: []SeekCheckpoint{
+			{Line: 0, MaxCreatedAt: start.Add(10 * time.Minute)},
+			{Line: 10}, // no line has a created_at
+			{Line: 20, MaxCreatedAt: start.Add(50 * time.Minute)},
+		},
+	}
+	for _, td := range []struct {
+		tm       time.Time
+		wantLine int64
+	}{
+		{tm: start.Add(5 * time.Minute), wantLine: 0},
+		{tm: start.Add(30 * time.Minute), wantLine: 10},
+		{tm: start.Add(55 * time.Minute), wantLine: 10},
+	} {
+		cp, ok := index.checkpointFor(td.tm)
+		require.True(t, ok)
+		require.Equal(t, td.wantLine, cp.Line, td.tm)
+	}
+	_, ok := new(SeekIndex).checkpointFor(start)
+	require.False(t, ok)
+}
+
+func TestScanner_SeekIndex(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	lines := testEventLines(start, 3000)
+	// gzip members that split lines, like concatenated gzip files
+	content := bytes.Join(lines, nil)
+	var data []byte
+	for len(content) > 0 {
+		n := 20_000
+		if n > len(content) {
+			n = len(content)
+		}
+		data = append(data, gzipLines(t, [][]byte{content[:n]})...)
+		content = content[n:]
+	}
+	name := ObjectName(start)
+	scanStart := start.Add(45 * time.Minute)
+	scan := func(src Source, seek bool, decodeConcurrency int) ([]string, Progress) {
+		t.Helper()
+		var progress Progress
+		scanner, err := New(ctx, scanStart, &Options{
+			Source:            src,
+			EndTime:           start.Add(time.Hour),
+			StrictTimeRange:   true,
+			SeekIndex:         seek,
+			DecodeConcurrency: decodeConcurrency,
+			Progress: func(p Progress) {
+				progress = p
+			},
+		})
+		require.NoError(t, err)
+		var got []string
+		for scanner.Scan(ctx) {
+			got = append(got, string(scanner.Bytes()))
+		}
+		require.NoError(t, scanner.Err())
+		require.NoError(t, scanner.Close())
+		return got, progress
+	}
+	writeIndex := func(src *DirSource, object []byte) *SeekIndex {
+		t.Helper()
+		index, err := BuildSeekIndex(bytes.NewReader(object), name)
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		_, err = index.WriteTo(&buf)
+		require.NoError(t, err)
+		writeObject(t, src, SeekIndexName(name), buf.Bytes())
+		return index
+	}
+
+	t.Run("seeks", func(t *testing.T) {
+		src := NewDirSource(t.TempDir())
+		writeObject(t, src, name, data)
+		writeIndex(src, data)
+		want, fullProgress := scan(src, false, 0)
+		require.NotEmpty(t, want)
+		for _, decodeConcurrency := range []int{0, 4} {
+			got, seekProgress := scan(src, true, decodeConcurrency)
+			require.Equal(t, want, got)
+			require.Less(t, seekProgress.BytesDownloaded, fullProgress.BytesDownloaded/2)
+			require.Less(t, seekProgress.LinesFiltered, fullProgress.LinesFiltered/2)
+		}
+	})
+
+	t.Run("index for the variant that isn't read", func(t *testing.T) {
+		// the zstd variant has an index but the gzip object is read
+		enc, err := zstd.NewWriter(nil)
+		require.NoError(t, err)
+		var zstdData []byte
+		for _, chunk := range [][][]byte{lines[:1000], lines[1000:2000], lines[2000:]} {
+			zstdData = enc.EncodeAll(bytes.Join(chunk, nil), zstdData)
+		}
+		require.NoError(t, enc.Close())
+		zstdName, ok := ZstdName(name)
+		require.True(t, ok)
+		src := NewDirSource(t.TempDir())
+		writeObject(t, src, name, data)
+		writeObject(t, src, zstdName, zstdData)
+		require.Equal(t, zstdName, writeIndex(src, zstdData).Object)
+		want, _ := scan(src, false, 0)
+		got, progress := scan(src, true, 0)
+		require.Equal(t, want, got)
+		require.Equal(t, int64(len(data)), progress.BytesDownloaded)
+	})
+}
@@ -1,13 +1,18 @@
 package gharchive
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"io"
-	"strings"
+	"log/slog"
 	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // singleScanner scans lines from gharchive
@@ -18,9 +23,21 @@ type singleScanner struct {
 	startTime   time.Time
 	endTime     time.Time
 	curHour     time.Time
+	rangeStart  time.Time // events before rangeStart are skipped when opts.StrictTimeRange is set
+	rangeEnd    time.Time // events at or after rangeEnd are skipped when opts.StrictTimeRange is set
 	lineScanner *lineScanner
 	hourReader  *objReader
 	brBuffer    []byte
+	line        []byte
+	batch       *lineBatch
+	progress    *progressReporter
+	corruptions *corruptionReporter
+	hourStats   Progress // lines counted for curHour
+	lineCounts  lineCounts
+	stats       *validatorStats
+	tracer      trace.Tracer
+	logger      *slog.Logger
+	readSpan    trace.Span // covers reading curHour after it is opened
 	err         error
 }
 
@@ -35,21 +52,36 @@ func newSingleScanner(ctx context.Context, startTime time.Time, opts *Options) (
 	if endTime.IsZero() {
 		endTime = startTime.Add(time.Hour)
 	}
+	rangeEnd := endTime
+	period := opts.Layout().Period()
+	hours := hourCount(startTime, endTime, period)
+	if opts.SingleHour {
+		rangeEnd = startTime.Truncate(period).Add(period)
+		hours = 1
+	}
 	return &singleScanner{
-		opts:      opts,
-		bucket:    opts.Bucket,
-		client:    opts.StorageClient,
-		startTime: startTime.UTC(),
-		endTime:   endTime.UTC(),
+		opts:        opts,
+		bucket:      opts.Bucket,
+		client:      opts.StorageClient,
+		startTime:   startTime.UTC(),
+		endTime:     endTime.UTC(),
+		rangeStart:  startTime.UTC(),
+		rangeEnd:    rangeEnd.UTC(),
+		progress:    newProgressReporter(opts.Progress, hours),
+		corruptions: newCorruptionReporter(opts.ReportCorruption, opts.logger()),
+		tracer:      opts.tracer(),
+		logger:      opts.logger(),
+		stats:       newValidatorStats(len(opts.Validators)),
 	}, nil
 }
 
 func (s *singleScanner) iterateCurHour() {
+	period := s.opts.Layout().Period()
 	if s.curHour.IsZero() {
-		s.curHour = s.startTime.Truncate(time.Hour)
+		s.curHour = s.startTime.Truncate(period)
 		return
 	}
-	s.curHour = s.curHour.Add(time.Hour)
+	s.curHour = s.curHour.Add(period)
 }
 
 // Close closes the scanner
@@ -67,25 +99,18 @@ func (s *singleScanner) Close() error {
 	return err
 }
 
-func (s *singleScanner) validateLine(line []byte) bool {
-	for _, validator := range s.opts.Validators {
-		ok := validator(line)
-		if !ok {
-			return false
-		}
-	}
-	return true
-}
-
 func (s *singleScanner) prepLineScanner(ctx context.Context) error {
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
-	if len(s.brBuffer) == 0 {
-		s.brBuffer = make([]byte, 8192)
+	if s.brBuffer == nil {
+		s.brBuffer = make([]byte, 0, 8192)
 	}
 	if s.lineScanner != nil {
 		err := s.lineScanner.error()
+		if err == io.EOF {
+			s.reportHour()
+		}
 		if s.opts.SingleHour || err != io.EOF {
 			return err
 		}
@@ -95,16 +120,37 @@ func (s *singleScanner) prepLineScanner(ctx context.Context) error {
 	// either way we need to do the same thing.
 
 	if s.hourReader == nil {
-		s.hourReader = new(objReader)
+		s.hourReader = &objReader{
+			corruptions: s.corruptions,
+		}
 	}
 	s.iterateCurHour()
-	if s.curHour.After(s.endTime) {
-		return io.EOF
+	for {
+		if !s.curHour.Before(s.endTime) {
+			return io.EOF
+		}
+		skip, err := s.skipHour(ctx)
+		if err != nil {
+			return err
+		}
+		if !skip {
+			break
+		}
+		if s.opts.SingleHour {
+			return io.EOF
+		}
+		s.iterateCurHour()
 	}
-	err := s.hourReader.newObj(ctx, s.curHour, s.opts)
+	err := s.hourReader.newObj(ctx, s.curHour, s.opts, s.tracer, s.seekTarget(ctx))
 	if err != nil {
+		s.logger.Error("failed to open hour", "hour", s.curHour, "object", s.hourReader.name, "error", err)
 		return err
 	}
+	s.logger.Debug("opened hour", "hour", s.curHour, "object", s.hourReader.name)
+	_, s.readSpan = s.tracer.Start(ctx, "gharchive.readHour", trace.WithAttributes(
+		hourAttr(s.curHour),
+		attrObject.String(s.hourReader.name),
+	))
 	s.lineScanner = &lineScanner{
 		br: byteReader{
 			data: s.brBuffer,
@@ -116,7 +162,7 @@ func (s *singleScanner) prepLineScanner(ctx context.Context) error {
 
 // Bytes returns the current line
 func (s *singleScanner) Bytes() []byte {
-	return s.lineScanner.bytes()
+	return s.line
 }
 
 // Err returns the scanner's error
@@ -130,36 +176,305 @@ func (s *singleScanner) Err() error {
 
 // Scan advances to the next line
 func (s *singleScanner) Scan(ctx context.Context) bool {
+	if s.err != nil {
+		return false
+	}
 	for {
 		if ctx.Err() != nil {
-			s.err = ctx.Err()
+			s.stop(ctx.Err())
 			return false
 		}
 		err := s.prepLineScanner(ctx)
 		if err != nil {
-			s.err = err
+			s.stop(err)
 			return false
 		}
-		s.lineScanner.scan()
-		if s.validateLine(s.lineScanner.bytes()) {
+		if !s.lineScanner.scan() {
+			// the hour is done. prepLineScanner reports it and opens the next one.
+			continue
+		}
+		s.lineCounts.scanned++
+		line := s.lineScanner.bytes()
+		if s.opts.NormalizeLegacy && legacyHour(s.curHour) {
+			line, _ = NormalizeLegacyEvent(line)
+		}
+		s.line = line
+		if s.opts.StrictTimeRange {
+			inRange, pastEnd := s.checkTimeRange(line)
+			if pastEnd {
+				// events are close enough to chronological order that nothing later in the hour can be in range
+				s.hourStats.LinesFiltered++
+				s.reportHour()
+				s.logger.Info("stopping because events are past the end time", "hour", s.curHour)
+				s.stop(io.EOF)
+				return false
+			}
+			if !inRange {
+				s.hourStats.LinesFiltered++
+				continue
+			}
+		}
+		rejectedBy := rejectingValidator(s.opts.Validators, line)
+		s.lineCounts.check(rejectedBy)
+		if s.lineCounts.scanned >= batchMaxLines {
+			s.lineCounts.flush(s.opts.Metrics, s.stats)
+		}
+		if rejectedBy == -1 {
+			s.hourStats.LinesEmitted++
 			return true
 		}
+		s.hourStats.LinesFiltered++
+	}
+}
+
+// stop ends the scan with err and ends the span for the current hour.
+func (s *singleScanner) stop(err error) {
+	s.err = err
+	if s.hourReader != nil {
+		s.hourReader.stopDecoding()
+	}
+	if errors.Is(err, context.Canceled) {
+		s.logger.Info("scan canceled", "hour", s.curHour)
+	}
+	s.endReadSpan(err)
+}
+
+// endReadSpan ends the span for reading curHour when there is one.
+func (s *singleScanner) endReadSpan(err error) {
+	if s.readSpan == nil {
+		return
+	}
+	endSpan(s.readSpan, err,
+		attrBytesDownloaded.Int64(s.hourReader.downloaded.count),
+		attrBytesDecompressed.Int64(s.hourReader.decompressed),
+		attrLines.Int64(s.hourStats.LinesEmitted+s.hourStats.LinesFiltered),
+	)
+	s.readSpan = nil
+}
+
+// reportHour reports progress and metrics for curHour, which has been read to the end, and resets the counts.
+func (s *singleScanner) reportHour() {
+	s.endReadSpan(nil)
+	s.lineCounts.flush(s.opts.Metrics, s.stats)
+	if s.opts.Metrics != nil {
+		s.opts.Metrics.HourRead(time.Since(s.hourReader.opened), s.hourReader.downloaded.count, s.hourReader.decompressed)
+	}
+	stats := s.hourStats
+	s.hourStats = Progress{}
+	s.logger.Debug("finished hour",
+		"hour", s.curHour,
+		"bytes_downloaded", s.hourReader.downloaded.count,
+		"bytes_decompressed", s.hourReader.decompressed,
+		"lines", stats.LinesEmitted+stats.LinesFiltered,
+		"duration", time.Since(s.hourReader.opened),
+	)
+	if s.progress == nil {
+		return
 	}
+	stats.Hour = s.curHour
+	stats.BytesDownloaded = s.hourReader.downloaded.count
+	stats.BytesDecompressed = s.hourReader.decompressed
+	s.progress.report(stats)
 }
 
+// skipHour returns true when the index for curHour shows that it can't match opts.IndexFilter. Skipped hours are
+// reported to progress. Hours with an index that can't be read are scanned.
+func (s *singleScanner) skipHour(ctx context.Context) (bool, error) {
+	if len(s.opts.IndexFilter) == 0 {
+		return false, nil
+	}
+	name := s.opts.Layout().ObjectName(s.curHour)
+	skip, err := indexFilterSkips(ctx, s.opts.indexSource(), name, s.opts.IndexFilter)
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		s.logger.Warn("failed to read hour index", "hour", s.curHour, "index", IndexName(name), "error", err)
+		return false, nil
+	}
+	if !skip {
+		return false, nil
+	}
+	s.logger.Debug("skipped hour by index", "hour", s.curHour, "index", IndexName(name))
+	s.progress.report(Progress{
+		Hour:    s.curHour,
+		Skipped: true,
+	})
+	return true, nil
+}
+
+// seekTarget returns where to start reading curHour when its seek index has a checkpoint after the start of the hour
+// that is before every event at or after rangeStart. It returns nil when the hour is read from the start.
+func (s *singleScanner) seekTarget(ctx context.Context) *seekTarget {
+	if !s.opts.SeekIndex || !s.opts.StrictTimeRange || !s.rangeStart.After(s.curHour) {
+		return nil
+	}
+	if _, ok := s.opts.source().(RangeSource); !ok {
+		return nil
+	}
+	name := s.opts.Layout().ObjectName(s.curHour)
+	index, err := readSeekIndex(ctx, s.opts.indexSource(), name)
+	if err != nil {
+		s.logger.Warn("failed to read seek index", "hour", s.curHour, "index", SeekIndexName(name), "error", err)
+		return nil
+	}
+	if index == nil {
+		return nil
+	}
+	checkpoint, ok := index.checkpointFor(s.rangeStart)
+	if !ok || checkpoint.DecompressedOffset == 0 {
+		return nil
+	}
+	s.logger.Debug("seeking in hour", "hour", s.curHour, "object", index.Object, "offset", checkpoint.Offset,
+		"line", checkpoint.Line)
+	return &seekTarget{
+		object:     index.Object,
+		checkpoint: checkpoint,
+	}
+}
+
+// strictTimeSlack is how far past rangeEnd an event's created_at must be before we assume no events after it
+// will be in range. Events in an hour file are roughly, but not strictly, in created_at order.
+const strictTimeSlack = 5 * time.Minute
+
+// checkTimeRange checks an event's created_at against rangeStart and rangeEnd. pastEnd is true when curHour is the
+// last hour in the range and created_at is far enough past rangeEnd that the scan can stop. A late event in an
+// earlier hour is only filtered. Lines without a created_at are not in range.
+func (s *singleScanner) checkTimeRange(line []byte) (inRange, pastEnd bool) {
+	createdAt, ok := eventCreatedAt(line)
+	if !ok {
+		return false, false
+	}
+	if !createdAt.Before(s.rangeEnd) {
+		lastHour := !s.curHour.Add(s.opts.Layout().Period()).Before(s.rangeEnd)
+		return false, lastHour && createdAt.After(s.rangeEnd.Add(strictTimeSlack))
+	}
+	return !createdAt.Before(s.rangeStart), false
+}
+
+// NextBatch returns a batch of lines copied from the scanner. The lines are valid until the next call to NextBatch.
+func (s *singleScanner) NextBatch(ctx context.Context) [][]byte {
+	if s.batch != nil {
+		s.batch.release()
+	}
+	s.batch = s.takeBatch(ctx)
+	if s.batch == nil {
+		return nil
+	}
+	return s.batch.lines
+}
+
+// takeBatch copies the next batch of lines from the scanner and hands ownership of it to the caller. It returns nil
+// at the end of the scan.
+func (s *singleScanner) takeBatch(ctx context.Context) *lineBatch {
+	batch := newLineBatch()
+	for len(batch.lines) < batchMaxLines && len(batch.buf) < batchBufSize && s.Scan(ctx) {
+		batch.add(s.Bytes())
+	}
+	if len(batch.lines) == 0 {
+		batch.release()
+		return nil
+	}
+	return batch
+}
+
+// ValidatorStats returns counts for each of opts.Validators.
+func (s *singleScanner) ValidatorStats() []ValidatorStats {
+	return s.stats.snapshot()
+}
+
+// BufferedBytes always returns 0 because singleScanner doesn't buffer lines ahead of the consumer.
+func (s *singleScanner) BufferedBytes() int64 {
+	return 0
+}
+
+// objReader decompresses an object. Objects that start with zstdMagic are read as zstd and others as gzip.
 type objReader struct {
-	rdr   io.Reader
-	gzRdr *gzip.Reader
+	rdr               io.Reader
+	br                *bufio.Reader // buffers downloaded so that offset knows how much the decompressor has read
+	gzRdr             *gzip.Reader
+	zstdRdr           *zstd.Decoder
+	isZstd            bool // the current object is zstd
+	downloaded        *countingReader
+	decompressed      int64
+	opened            time.Time
+	name              string
+	hour              time.Time
+	policy            CorruptionPolicy
+	corruptions       *corruptionReporter
+	skipRest          bool  // set when the rest of the object is skipped because of corrupt data
+	baseOffset        int64 // offset in the object where reading started
+	decodeConcurrency int
+	frames            *zstdFrameDecoder // decompresses zstd objects with small frames, in parallel when decodeConcurrency > 1
+	decoded           *readAhead        // runs read on its own goroutine when decodeConcurrency > 1
 }
 
 func (z *objReader) Read(p []byte) (n int, err error) {
-	return z.gzRdr.Read(p)
+	if z.decoded != nil {
+		return z.decoded.Read(p)
+	}
+	return z.read(p)
 }
 
+// read decompresses data from the current object.
+func (z *objReader) read(p []byte) (n int, err error) {
+	if z.skipRest {
+		return 0, io.EOF
+	}
+	switch {
+	case z.frames != nil:
+		n, err = z.frames.Read(p)
+	case z.isZstd:
+		n, err = z.zstdRdr.Read(p)
+	default:
+		n, err = z.gzRdr.Read(p)
+	}
+	z.decompressed += int64(n)
+	if err != nil && err != io.EOF {
+		err = z.handleCorruption(err)
+	}
+	return n, err
+}
+
+// Close closes the current object and releases the zstd decoder.
 func (z *objReader) Close() error {
+	err := z.closeObj()
+	if z.zstdRdr != nil {
+		z.zstdRdr.Close()
+		z.zstdRdr = nil
+	}
+	return err
+}
+
+// stopDecoding stops the goroutines that decompress the current object. Counts are stable once it returns.
+func (z *objReader) stopDecoding() {
+	// stop the download first so that the decompressor isn't left waiting for it
+	if downloaded, ok := z.rdr.(*countingReader); ok {
+		if download, ok := downloaded.r.(*readAhead); ok {
+			_ = download.Close() //nolint:errcheck // closeObj closes it again and returns the error
+		}
+	}
+	if z.decoded != nil {
+		_ = z.decoded.Close() //nolint:errcheck // it doesn't close anything that can fail
+	}
+	if z.frames != nil {
+		z.frames.Close()
+	}
+}
+
+// closeObj closes the current object.
+func (z *objReader) closeObj() error {
+	z.stopDecoding()
+	z.decoded = nil
+	z.frames = nil
 	var err error
-	if z.gzRdr != nil {
+	// the decompressor's error was already handled when the rest of the object is skipped
+	if z.gzRdr != nil && !z.isZstd && !z.skipRest {
 		err = z.gzRdr.Close()
+		// stopDecoding interrupts a read in progress. that isn't a problem with the object.
+		if errors.Is(err, errReadAheadClosed) {
+			err = nil
+		}
 	}
 	if z.rdr == nil {
 		return err
@@ -174,29 +489,205 @@ func (z *objReader) Close() error {
 }
 
 func (z *objReader) Reset(r io.Reader) error {
-	err := z.Close()
+	err := z.closeObj()
 	if err != nil {
 		return err
 	}
 	z.rdr = r
-	if z.gzRdr == nil {
-		z.gzRdr, err = gzip.NewReader(r)
+	z.skipRest = false
+	if z.br == nil {
+		z.br = bufio.NewReaderSize(r, 32*1024)
+	} else {
+		z.br.Reset(r)
+	}
+	magic, _ := z.br.Peek(len(zstdMagic)) //nolint:errcheck // the decompressor gets the same error
+	z.isZstd = bytes.Equal(magic, zstdMagic)
+	err = z.resetDecompressor()
+	if err != nil {
+		err = z.handleCorruption(err)
+	}
+	if err != nil && err != errCorruptionSkipped {
 		return err
 	}
-	return z.gzRdr.Reset(r)
+	if z.decodeConcurrency > 1 {
+		z.decoded = newReadAhead(readFunc(z.read))
+	}
+	return nil
 }
 
-func (z *objReader) newObj(ctx context.Context, hour time.Time, opts *Options) error {
-	var err error
-	tm := hour.UTC()
+// resetDecompressor starts decompressing the data in br.
+func (z *objReader) resetDecompressor() error {
+	switch {
+	case !z.isZstd:
+		if z.gzRdr == nil {
+			z.gzRdr = new(gzip.Reader)
+		}
+		return z.gzRdr.Reset(z.br)
+	case z.hasSmallFrames():
+		if z.frames != nil {
+			z.frames.Close()
+		}
+		var err error
+		z.frames, err = newZstdFrameDecoder(z.br, z.offset, z.decodeConcurrency)
+		return err
+	case z.zstdRdr == nil:
+		// the decoder runs on its own goroutine. more concurrency only helps with DecodeAll.
+		var err error
+		z.zstdRdr, err = zstd.NewReader(z.br, zstd.WithDecoderConcurrency(1))
+		return err
+	default:
+		return z.zstdRdr.Reset(z.br)
+	}
+}
+
+// hasSmallFrames returns true when the zstd frame at the start of br says its content fits in maxZstdFrameSize, like
+// the frames gharchive recompress writes. Frames are decompressed whole then, which lets them be decompressed in
+// parallel and lets resync start exactly at the frame after a bad one.
+func (z *objReader) hasSmallFrames() bool {
+	size, ok := peekZstdFrameSize(z.br)
+	return ok && size <= maxZstdFrameSize
+}
+
+// offset returns the offset in the compressed object of the next byte the decompressor will read.
+func (z *objReader) offset() int64 {
+	return z.baseOffset + z.downloaded.count - int64(z.br.Buffered())
+}
 
-	// this hack is required to get a single-digit hour in the object name
-	obj := tm.UTC().Format("2006-01-02-")
-	obj += strings.TrimPrefix(tm.UTC().Format("15.json.gz"), "0")
+// handleCorruption applies the corruption policy when err from the decompressor is caused by corrupt data. It returns
+// errCorruptionSkipped when the policy skipped the corruption.
+func (z *objReader) handleCorruption(err error) error {
+	if z.downloaded.err != nil || !(z.isZstd || isCorruption(err)) {
+		return err
+	}
+	corruption := &CorruptionError{
+		Hour:    z.hour,
+		Object:  z.name,
+		Resumed: -1,
+		Err:     err,
+	}
+	// a bad frame from z.frames doesn't stop it, so its offsets are known without looking at br
+	frameErr, badFrame := err.(*zstdFrameError)
+	if badFrame {
+		corruption.Offset = frameErr.start
+		corruption.Err = frameErr.err
+	} else {
+		corruption.Offset = z.offset()
+	}
+	switch {
+	case z.policy == CorruptionSkipHour:
+		z.skipRest = true
+	case z.policy == CorruptionResync && badFrame:
+		corruption.Resumed = frameErr.end
+	case z.policy == CorruptionResync:
+		resumed, resyncErr := z.resync()
+		if resyncErr != nil {
+			return resyncErr
+		}
+		corruption.Resumed = resumed
+		z.skipRest = resumed == -1
+	default:
+		return corruption
+	}
+	z.corruptions.report(corruption)
+	return errCorruptionSkipped
+}
 
-	rdr, err := opts.StorageClient.Bucket(opts.Bucket).Object(obj).NewReader(ctx)
+// resync discards compressed data up to the next gzip member or zstd frame and restarts the decompressor there. It
+// returns the offset of the member or frame or -1 when there isn't another one.
+func (z *objReader) resync() (int64, error) {
+	magic := gzipMagic
+	if z.isZstd {
+		magic = zstdMagic
+	}
+	for {
+		peeked, err := z.br.Peek(len(magic))
+		if err == io.EOF {
+			return -1, nil
+		}
+		if err != nil {
+			return -1, err
+		}
+		if !bytes.Equal(peeked, magic) {
+			_, err = z.br.Discard(1)
+			if err != nil {
+				return -1, err
+			}
+			continue
+		}
+		start := z.offset()
+		err = z.resetDecompressor()
+		if err == nil {
+			return start, nil
+		}
+		if z.downloaded.err != nil || !isCorruption(err) {
+			return -1, err
+		}
+		// the magic bytes were a coincidence. keep looking after them.
+	}
+}
+
+// seekTarget is a checkpoint to start reading an object from.
+type seekTarget struct {
+	object     string // the name of the object the checkpoint is in, which may be a variant
+	checkpoint SeekCheckpoint
+}
+
+// newObj opens the object for hour. When seek isn't nil, it starts reading at seek's checkpoint.
+func (z *objReader) newObj(ctx context.Context, hour time.Time, opts *Options, tracer trace.Tracer, seek *seekTarget) (err error) {
+	obj := opts.Layout().ObjectName(hour)
+	ctx, span := tracer.Start(ctx, "gharchive.openHour", trace.WithAttributes(
+		hourAttr(hour),
+		attrObject.String(obj),
+	))
+	defer func() {
+		endSpan(span, err)
+	}()
+	z.opened = time.Now()
+	z.name = obj
+	z.hour = hour
+	z.policy = opts.OnCorruption
+	z.decodeConcurrency = opts.DecodeConcurrency
+	z.baseOffset = 0
+	var rdr io.ReadCloser
+	if seek != nil {
+		// open the variant that Open would read
+		var opened string
+		rdr, opened, err = openVariant(obj, func(name string) (io.ReadCloser, error) {
+			return opts.source().(RangeSource).OpenRange(ctx, name, seek.checkpoint.Offset)
+		})
+		if err == nil && opened != seek.object {
+			// the index is for a different variant
+			_ = rdr.Close() //nolint:errcheck // nothing was read
+			seek = nil
+		}
+		if err == ErrObjectNotExist {
+			seek = nil
+		}
+	}
+	if seek == nil {
+		rdr, err = opts.source().Open(ctx, obj)
+	}
 	if err != nil {
 		return err
 	}
-	return z.Reset(rdr)
+	if seek != nil {
+		z.baseOffset = seek.checkpoint.Offset
+	}
+	if z.decodeConcurrency > 1 {
+		// download ahead of the decompressor
+		z.downloaded = &countingReader{r: newReadAhead(rdr)}
+	} else {
+		z.downloaded = &countingReader{r: rdr}
+	}
+	z.decompressed = 0
+	err = z.Reset(z.downloaded)
+	if err != nil || seek == nil {
+		return err
+	}
+	_, err = io.CopyN(io.Discard, z, seek.checkpoint.SkipBytes)
+	if err == io.EOF {
+		// the object is shorter than its index says
+		err = io.ErrUnexpectedEOF
+	}
+	return err
 }
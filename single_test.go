@@ -2,12 +2,59 @@ package gharchive
 
 import (
 	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
 )
 
+// writeHourFiles writes a gzipped object to dir for each hour in content and returns a DirSource for dir.
+func writeHourFiles(t *testing.T, content map[time.Time]string) *DirSource {
+	t.Helper()
+	dir := t.TempDir()
+	for hour, data := range content {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, ObjectName(hour)), gzipString(t, data), 0o600))
+	}
+	return NewDirSource(dir)
+}
+
+// singleScannerLines returns the lines a singleScanner returns from start with opts.
+func singleScannerLines(t *testing.T, start time.Time, opts *Options) []string {
+	t.Helper()
+	ctx := context.Background()
+	scanner, err := newSingleScanner(ctx, start, opts)
+	require.NoError(t, err)
+	got := []string{}
+	for scanner.Scan(ctx) {
+		got = append(got, string(scanner.Bytes()))
+	}
+	require.NoError(t, scanner.Err())
+	require.NoError(t, scanner.Close())
+	return got
+}
+
+// openCountingSource counts the times each object is opened.
+type openCountingSource struct {
+	Source
+	mu    sync.Mutex
+	opens map[string]int
+}
+
+func (c *openCountingSource) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	c.mu.Lock()
+	if c.opens == nil {
+		c.opens = map[string]int{}
+	}
+	c.opens[name]++
+	c.mu.Unlock()
+	return c.Source.Open(ctx, name)
+}
+
 func Test_singleScanner(t *testing.T) {
 	t.Run("short", func(t *testing.T) {
 		t.Run("multi-hour", func(t *testing.T) {
@@ -25,7 +72,7 @@ func Test_singleScanner(t *testing.T) {
 				count++
 			}
 			require.NoError(t, scanner.Err())
-			require.Equal(t, 33, count)
+			require.Equal(t, 30, count)
 		})
 
 		t.Run("single hour", func(t *testing.T) {
@@ -43,7 +90,7 @@ func Test_singleScanner(t *testing.T) {
 				got = append(got, scanner.Bytes())
 			}
 			require.NoError(t, scanner.Err())
-			require.Len(t, got, 11)
+			require.Len(t, got, 10)
 		})
 	})
 
@@ -66,7 +113,7 @@ func Test_singleScanner(t *testing.T) {
 				count++
 			}
 			require.NoError(t, scanner.Err())
-			require.Equal(t, 280628, count)
+			require.Equal(t, 280625, count)
 		})
 
 		t.Run("single hour", func(t *testing.T) {
@@ -84,7 +131,180 @@ func Test_singleScanner(t *testing.T) {
 				count++
 			}
 			require.NoError(t, scanner.Err())
-			require.Equal(t, 92993, count)
+			require.Equal(t, 92992, count)
+		})
+	})
+}
+
+func Test_singleScanner_lines(t *testing.T) {
+	hour := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	for _, td := range []struct {
+		name    string
+		content []string
+		want    []string
+	}{
+		{
+			name:    "trailing newlines",
+			content: []string{"a\nb\n", "c\n"},
+			want:    []string{"a\n", "b\n", "c\n"},
+		},
+		{
+			name:    "no trailing newline",
+			content: []string{"a\nb", "c"},
+			want:    []string{"a\n", "b", "c"},
+		},
+		{
+			name:    "empty hours",
+			content: []string{"", "a\n", ""},
+			want:    []string{"a\n"},
+		},
+		{
+			name:    "blank lines",
+			content: []string{"\n", "a\n\n"},
+			want:    []string{"\n", "a\n", "\n"},
+		},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			content := map[time.Time]string{}
+			for i, data := range td.content {
+				content[hour.Add(time.Duration(i)*time.Hour)] = data
+			}
+			got := singleScannerLines(t, hour, &Options{
+				Source:  writeHourFiles(t, content),
+				EndTime: hour.Add(time.Duration(len(td.content)) * time.Hour),
+			})
+			require.Equal(t, td.want, got)
+		})
+	}
+}
+
+func Test_singleScanner_hourRange(t *testing.T) {
+	hour := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	src := writeHourFiles(t, map[time.Time]string{
+		hour:                    "8a\n8b\n",
+		hour.Add(time.Hour):     "9a\n",
+		hour.Add(2 * time.Hour): "10a\n",
+	})
+	for _, td := range []struct {
+		name       string
+		start, end time.Time
+		want       []string
+	}{
+		{
+			name:  "end on an hour boundary",
+			start: hour,
+			end:   hour.Add(2 * time.Hour),
+			want:  []string{"8a\n", "8b\n", "9a\n"},
+		},
+		{
+			name:  "end mid-hour",
+			start: hour,
+			end:   hour.Add(90 * time.Minute),
+			want:  []string{"8a\n", "8b\n", "9a\n"},
+		},
+		{
+			name:  "start mid-hour",
+			start: hour.Add(30 * time.Minute),
+			end:   hour.Add(time.Hour),
+			want:  []string{"8a\n", "8b\n"},
+		},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			got := singleScannerLines(t, td.start, &Options{
+				Source:  src,
+				EndTime: td.end,
+			})
+			require.Equal(t, td.want, got)
 		})
+	}
+}
+
+func TestScanner_StrictTimeRange(t *testing.T) {
+	firstHour := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	src := writeEventHours(t, firstHour, firstHour.Add(3*time.Hour), 1000)
+	start := firstHour.Add(20 * time.Minute)
+	end := firstHour.Add(150 * time.Minute)
+	all := scanLines(t, firstHour, &Options{
+		Source:        src,
+		EndTime:       firstHour.Add(3 * time.Hour),
+		PreserveOrder: true,
+	})
+	var want []string
+	for _, line := range all {
+		var event struct {
+			CreatedAt time.Time `json:"created_at"`
+		}
+		if json.Unmarshal([]byte(line), &event) != nil {
+			continue
+		}
+		if !event.CreatedAt.Before(start) && event.CreatedAt.Before(end) {
+			want = append(want, line)
+		}
+	}
+	require.NotEmpty(t, want)
+
+	t.Run("preserve order", func(t *testing.T) {
+		got := scanLines(t, start, &Options{
+			Source:          src,
+			EndTime:         end,
+			PreserveOrder:   true,
+			StrictTimeRange: true,
+		})
+		require.Equal(t, want, got)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		got := scanLines(t, start, &Options{
+			Source:          src,
+			EndTime:         end,
+			Concurrency:     3,
+			StrictTimeRange: true,
+		})
+		require.ElementsMatch(t, want, got)
+	})
+
+	late := []byte(`{"id":"late","created_at":"2020-10-10T12:00:00Z"}` + "\n")
+	withLate := func(hour time.Time) [][]byte {
+		lines := testEventLines(hour, 10)
+		return append(lines[:5], append([][]byte{late}, lines[5:]...)...)
+	}
+	scanWithProgress := func(t *testing.T, src Source) ([]string, []Progress) {
+		t.Helper()
+		var progress []Progress
+		got := scanLines(t, firstHour, &Options{
+			Source:          src,
+			EndTime:         firstHour.Add(2 * time.Hour),
+			PreserveOrder:   true,
+			StrictTimeRange: true,
+			Progress: func(p Progress) {
+				progress = append(progress, p)
+			},
+		})
+		return got, progress
+	}
+
+	t.Run("filters late events before the last hour", func(t *testing.T) {
+		src := writeEventHours(t, firstHour, firstHour.Add(2*time.Hour), 10)
+		writeObject(t, src, ObjectName(firstHour), gzipLines(t, withLate(firstHour)))
+		got, progress := scanWithProgress(t, src)
+		require.Len(t, got, 20)
+		require.Len(t, progress, 2)
+		require.Equal(t, int64(10), progress[0].LinesEmitted)
+		require.Equal(t, int64(1), progress[0].LinesFiltered)
+		require.Equal(t, int64(10), progress[1].LinesEmitted)
+	})
+
+	t.Run("stops past the end in the last hour", func(t *testing.T) {
+		lastHour := firstHour.Add(time.Hour)
+		src := writeEventHours(t, firstHour, firstHour.Add(2*time.Hour), 10)
+		writeObject(t, src, ObjectName(lastHour), gzipLines(t, withLate(lastHour)))
+		counting := &openCountingSource{Source: src}
+		got, progress := scanWithProgress(t, counting)
+		require.Len(t, got, 15)
+		require.Equal(t, map[string]int{ObjectName(firstHour): 1, ObjectName(lastHour): 1}, counting.opens)
+		require.Len(t, progress, 2)
+		require.Equal(t, lastHour, progress[1].Hour)
+		require.Equal(t, int64(5), progress[1].LinesEmitted)
+		require.Equal(t, int64(1), progress[1].LinesFiltered)
 	})
 }
@@ -0,0 +1,310 @@
+package gharchive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// ErrObjectNotExist is returned by a Source for objects that don't exist. It is the same error as
+// storage.ErrObjectNotExist.
+var ErrObjectNotExist = storage.ErrObjectNotExist
+
+// ErrChecksumMismatch matches a *ChecksumError with errors.Is.
+var ErrChecksumMismatch = errors.New("object content doesn't match its size and checksum")
+
+// ChecksumError is returned when the content read from an object doesn't match the size and CRC32C checksum in
+// its attributes.
+type ChecksumError struct {
+	Name       string
+	WantSize   int64
+	GotSize    int64
+	WantCRC32C uint32
+	GotCRC32C  uint32
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s: read %d bytes with crc32c %08x, want %d bytes with crc32c %08x",
+		e.Name, e.GotSize, e.GotCRC32C, e.WantSize, e.WantCRC32C)
+}
+
+// Is returns true for ErrChecksumMismatch.
+func (e *ChecksumError) Is(target error) bool {
+	return target == ErrChecksumMismatch
+}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// verifyingReader checks the size and CRC32C checksum of everything read from r when r reaches EOF. It returns a
+// *ChecksumError instead of io.EOF on a mismatch.
+type verifyingReader struct {
+	r      io.ReadCloser
+	name   string
+	size   int64
+	crc32c uint32
+	gotCRC uint32
+	got    int64
+}
+
+// NewVerifyingReader returns a reader that returns a *ChecksumError instead of io.EOF when the data read from r
+// doesn't match attrs.
+func NewVerifyingReader(r io.ReadCloser, attrs *ObjectAttrs) io.ReadCloser {
+	return &verifyingReader{
+		r:      r,
+		name:   attrs.Name,
+		size:   attrs.Size,
+		crc32c: attrs.CRC32C,
+	}
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.got += int64(n)
+	v.gotCRC = crc32.Update(v.gotCRC, crc32cTable, p[:n])
+	if err == io.EOF && (v.got != v.size || v.gotCRC != v.crc32c) {
+		err = &ChecksumError{
+			Name:       v.name,
+			WantSize:   v.size,
+			GotSize:    v.got,
+			WantCRC32C: v.crc32c,
+			GotCRC32C:  v.gotCRC,
+		}
+	}
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	return v.r.Close()
+}
+
+// ObjectAttrs are the attributes of an object in a Source.
+type ObjectAttrs struct {
+	Name    string
+	Size    int64
+	CRC32C  uint32 // CRC32 checksum of the object's data using the Castagnoli table
+	Updated time.Time
+}
+
+// Source provides the raw objects that hold events.
+type Source interface {
+	// Open returns a reader for the raw content of the named object. It returns ErrObjectNotExist when the object
+	// doesn't exist.
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+
+	// Attrs returns the attributes of the named object. It returns ErrObjectNotExist when the object doesn't exist.
+	Attrs(ctx context.Context, name string) (*ObjectAttrs, error)
+}
+
+// NewSource returns the Source that a Scanner with opts reads from.
+func NewSource(ctx context.Context, opts *Options) (Source, error) {
+	opts, err := opts.withDefaults(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return opts.source(), nil
+}
+
+// source returns o.Source or a GCSSource for o.StorageClient and o.Bucket. o must have defaults.
+func (o *Options) source() Source {
+	if o.Source != nil {
+		return o.Source
+	}
+	return NewGCSSource(o.StorageClient, o.Bucket)
+}
+
+// GCSSource is a Source for a Google Cloud Storage bucket.
+type GCSSource struct {
+	bucket *storage.BucketHandle
+}
+
+// NewGCSSource returns a Source for bucket.
+func NewGCSSource(client *storage.Client, bucket string) *GCSSource {
+	return &GCSSource{
+		bucket: client.Bucket(bucket),
+	}
+}
+
+// openVariant calls open with name and then with its ZstdName variant when name doesn't exist. It returns the name
+// that was opened. Every Source reads variants in this order.
+func openVariant(name string, open func(name string) (io.ReadCloser, error)) (io.ReadCloser, string, error) {
+	rdr, err := open(name)
+	if zstdName, ok := ZstdName(name); ok && err == ErrObjectNotExist {
+		name = zstdName
+		rdr, err = open(name)
+	}
+	return rdr, name, err
+}
+
+// Open implements Source. When name doesn't exist, Open reads its ZstdName variant if that exists. The reader returns a
+// *ChecksumError at the end of the object when the content doesn't match the object's size and CRC32C checksum.
+func (g *GCSSource) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	rdr, name, err := openVariant(name, func(name string) (io.ReadCloser, error) {
+		return g.bucket.Object(name).NewReader(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &gcsReader{
+		ctx:  ctx,
+		obj:  g.bucket.Object(name),
+		r:    rdr.(*storage.Reader),
+		name: name,
+	}, nil
+}
+
+// gcsReader computes the CRC32C checksum of everything read from r. At the end of the object it compares the size and
+// checksum with the object's attributes and returns a *ChecksumError when they don't match. That replaces io.EOF or
+// the error storage.Reader returns when the content doesn't match the checksum header.
+type gcsReader struct {
+	ctx     context.Context
+	obj     *storage.ObjectHandle
+	r       *storage.Reader
+	name    string
+	got     int64
+	gotCRC  uint32
+	checked bool
+}
+
+func (g *gcsReader) Read(p []byte) (int, error) {
+	n, err := g.r.Read(p)
+	g.got += int64(n)
+	g.gotCRC = crc32.Update(g.gotCRC, crc32cTable, p[:n])
+	if !g.checked && (err == io.EOF || (err != nil && g.got == g.r.Attrs.Size)) {
+		g.checked = true
+		err = g.check(err)
+	}
+	return n, err
+}
+
+// check returns err when the content read matches the attributes of the generation of the object that was read.
+func (g *gcsReader) check(err error) error {
+	attrs, attrsErr := g.obj.Generation(g.r.Attrs.Generation).Attrs(g.ctx)
+	if attrsErr != nil {
+		return attrsErr
+	}
+	if g.got == attrs.Size && g.gotCRC == attrs.CRC32C {
+		return err
+	}
+	return &ChecksumError{
+		Name:       g.name,
+		WantSize:   attrs.Size,
+		GotSize:    g.got,
+		WantCRC32C: attrs.CRC32C,
+		GotCRC32C:  g.gotCRC,
+	}
+}
+
+func (g *gcsReader) Close() error {
+	return g.r.Close()
+}
+
+// Attrs implements Source.
+func (g *GCSSource) Attrs(ctx context.Context, name string) (*ObjectAttrs, error) {
+	attrs, err := g.bucket.Object(name).Attrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectAttrs{
+		Name:    attrs.Name,
+		Size:    attrs.Size,
+		CRC32C:  attrs.CRC32C,
+		Updated: attrs.Updated,
+	}, nil
+}
+
+// RangeSource is a Source that can read objects from an offset.
+type RangeSource interface {
+	Source
+
+	// OpenRange returns a reader for the raw content of the named object starting at offset.
+	OpenRange(ctx context.Context, name string, offset int64) (io.ReadCloser, error)
+}
+
+// OpenRange implements RangeSource. Content read from an offset isn't verified.
+func (g *GCSSource) OpenRange(ctx context.Context, name string, offset int64) (io.ReadCloser, error) {
+	return g.bucket.Object(name).NewRangeReader(ctx, offset, -1)
+}
+
+// DirSource is a Source for a local directory of objects like one written by "gharchive mirror".
+type DirSource struct {
+	dir string
+}
+
+// NewDirSource returns a Source for the objects in dir.
+func NewDirSource(dir string) *DirSource {
+	return &DirSource{
+		dir: dir,
+	}
+}
+
+// Dir returns the directory d reads from.
+func (d *DirSource) Dir() string {
+	return d.dir
+}
+
+// Open implements Source. When name doesn't exist, Open reads its ZstdName variant if that exists.
+func (d *DirSource) Open(_ context.Context, name string) (io.ReadCloser, error) {
+	rdr, _, err := openVariant(name, func(name string) (io.ReadCloser, error) {
+		return d.open(name)
+	})
+	return rdr, err
+}
+
+// open opens name without looking for a variant.
+func (d *DirSource) open(name string) (*os.File, error) {
+	file, err := os.Open(filepath.Join(d.dir, filepath.Base(name)))
+	if os.IsNotExist(err) {
+		return nil, ErrObjectNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// OpenRange implements RangeSource. Unlike Open, it never uses a variant of name.
+func (d *DirSource) OpenRange(_ context.Context, name string, offset int64) (io.ReadCloser, error) {
+	file, err := d.open(name)
+	if err != nil {
+		return nil, err
+	}
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		_ = file.Close() //nolint:errcheck // the seek error is more useful
+		return nil, err
+	}
+	return file, nil
+}
+
+// Attrs implements Source. It reads the whole file to compute CRC32C. Unlike Open, it never uses a variant of name.
+func (d *DirSource) Attrs(_ context.Context, name string) (*ObjectAttrs, error) {
+	file, err := d.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close() //nolint:errcheck // read only
+	}()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	hash := crc32.New(crc32cTable)
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectAttrs{
+		Name:    name,
+		Size:    info.Size(),
+		CRC32C:  hash.Sum32(),
+		Updated: info.ModTime(),
+	}, nil
+}
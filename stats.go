@@ -0,0 +1,49 @@
+package gharchive
+
+import "sync/atomic"
+
+// ValidatorStats counts the lines checked by one of Options.Validators.
+type ValidatorStats struct {
+	Evaluated int64 // lines the validator was called with
+	Rejected  int64 // lines the validator returned false for
+}
+
+// validatorStats counts lines for each validator. It is safe to use from multiple goroutines.
+type validatorStats struct {
+	evaluated []int64
+	rejected  []int64
+}
+
+func newValidatorStats(validators int) *validatorStats {
+	return &validatorStats{
+		evaluated: make([]int64, validators),
+		rejected:  make([]int64, validators),
+	}
+}
+
+// add counts checked lines that were run through the validators in order, with rejected[i] of them rejected by
+// validator i. Validators after the one that rejected a line don't evaluate it.
+func (s *validatorStats) add(checked int64, rejected []int64) {
+	for i := range s.evaluated {
+		if checked == 0 {
+			return
+		}
+		atomic.AddInt64(&s.evaluated[i], checked)
+		if i < len(rejected) && rejected[i] > 0 {
+			atomic.AddInt64(&s.rejected[i], rejected[i])
+			checked -= rejected[i]
+		}
+	}
+}
+
+// snapshot returns the current counts.
+func (s *validatorStats) snapshot() []ValidatorStats {
+	stats := make([]ValidatorStats, len(s.evaluated))
+	for i := range stats {
+		stats[i] = ValidatorStats{
+			Evaluated: atomic.LoadInt64(&s.evaluated[i]),
+			Rejected:  atomic.LoadInt64(&s.rejected[i]),
+		}
+	}
+	return stats
+}
@@ -0,0 +1,62 @@
+package gharchive
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanner_ValidatorStats(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	end := start.Add(3 * time.Hour)
+	src := writeEventHours(t, start, end, 1000)
+	for _, opts := range []*Options{
+		{PreserveOrder: true},
+		{Concurrency: 3},
+		{Concurrency: 3, ValidationConcurrency: 4},
+	} {
+		fields := NewJSONFieldsValidator([]JSONFieldValidator{
+			{
+				Field: "type",
+				Validator: StringValueValidator(func(val string) bool {
+					return val == "PushEvent"
+				}),
+			},
+			{
+				Field: "not_a_field",
+				Validator: func(val interface{}) bool {
+					return true
+				},
+			},
+		})
+		opts.Source = src
+		opts.EndTime = end
+		opts.Validators = []Validator{
+			ValidateNotEmpty(),
+			fields.Validate,
+		}
+		scanner, err := New(ctx, start, opts)
+		require.NoError(t, err)
+		var count int64
+		for scanner.Scan(ctx) {
+			count++
+		}
+		require.NoError(t, scanner.Err())
+		require.NoError(t, scanner.Close())
+		require.Zero(t, count)
+		stats := scanner.ValidatorStats()
+		require.Equal(t, []ValidatorStats{
+			{Evaluated: 3000, Rejected: 0},
+			{Evaluated: 3000, Rejected: 3000},
+		}, stats)
+		fieldStats := fields.FieldStats()
+		require.Equal(t, "type", fieldStats[0].Field)
+		require.Equal(t, int64(3000), fieldStats[0].Evaluated)
+		pushes := 3000 - fieldStats[0].Rejected
+		require.Greater(t, pushes, int64(0))
+		require.Equal(t, FieldStats{Field: "not_a_field", Missing: pushes}, fieldStats[1])
+	}
+}
@@ -0,0 +1,52 @@
+package gharchive
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/willabides/gharchive-client"
+
+// span attribute keys
+const (
+	attrHour              = attribute.Key("gharchive.hour")
+	attrObject            = attribute.Key("gharchive.object")
+	attrBytesDownloaded   = attribute.Key("gharchive.bytes_downloaded")
+	attrBytesDecompressed = attribute.Key("gharchive.bytes_decompressed")
+	attrLines             = attribute.Key("gharchive.lines")
+	attrOutcome           = attribute.Key("gharchive.outcome")
+)
+
+// tracer returns a tracer from o.TracerProvider or a tracer that does nothing when it isn't set.
+func (o *Options) tracer() trace.Tracer {
+	if o.TracerProvider == nil {
+		return trace.NewNoopTracerProvider().Tracer(tracerName)
+	}
+	return o.TracerProvider.Tracer(tracerName)
+}
+
+func hourAttr(hour time.Time) attribute.KeyValue {
+	return attrHour.String(hour.UTC().Format(time.RFC3339))
+}
+
+// endSpan sets the outcome attribute from err along with attrs and ends span. io.EOF is a successful outcome.
+func endSpan(span trace.Span, err error, attrs ...attribute.KeyValue) {
+	outcome := "ok"
+	switch {
+	case err == nil || err == io.EOF:
+	case errors.Is(err, context.Canceled):
+		outcome = "canceled"
+	default:
+		outcome = "error"
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.SetAttributes(append(attrs, attrOutcome.String(outcome))...)
+	span.End()
+}
@@ -0,0 +1,63 @@
+package gharchive
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestScanner_TracerProvider(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	recorder := tracetest.NewSpanRecorder()
+	scanner, err := New(ctx, start, &Options{
+		Source: writeEventHours(t, start, start.Add(2*time.Hour), 100),
+		// the third hour is missing
+		EndTime:        start.Add(3 * time.Hour),
+		Concurrency:    3,
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+	})
+	require.NoError(t, err)
+	for scanner.Scan(ctx) {
+	}
+	require.Error(t, scanner.Err())
+	require.NoError(t, scanner.Close())
+
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+	require.Len(t, spans["gharchive.worker"], 3)
+	require.Len(t, spans["gharchive.openHour"], 3)
+	require.Len(t, spans["gharchive.readHour"], 2)
+	outcomes := map[string]int{}
+	for _, span := range spans["gharchive.openHour"] {
+		attrs := map[string]string{}
+		for _, kv := range span.Attributes() {
+			attrs[string(kv.Key)] = kv.Value.Emit()
+		}
+		outcomes[attrs["gharchive.outcome"]]++
+		if attrs["gharchive.outcome"] == "error" {
+			require.Equal(t, "2020-10-10-10.json.gz", attrs["gharchive.object"])
+		}
+	}
+	require.Equal(t, map[string]int{"ok": 2, "error": 1}, outcomes)
+	workers := map[string]bool{}
+	for _, span := range spans["gharchive.worker"] {
+		workers[span.SpanContext().SpanID().String()] = true
+	}
+	for _, span := range spans["gharchive.readHour"] {
+		require.True(t, workers[span.Parent().SpanID().String()])
+		attrs := map[string]string{}
+		for _, kv := range span.Attributes() {
+			attrs[string(kv.Key)] = kv.Value.Emit()
+		}
+		require.Equal(t, "ok", attrs["gharchive.outcome"])
+		require.Equal(t, "100", attrs["gharchive.lines"])
+		require.NotEqual(t, "0", attrs["gharchive.bytes_downloaded"])
+	}
+}
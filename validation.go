@@ -0,0 +1,169 @@
+package gharchive
+
+import (
+	"context"
+	"sync"
+)
+
+// validatingScanner runs validators on batches from another scanner across multiple goroutines while
+// preserving the order of the other scanner's output.
+type validatingScanner struct {
+	inner      iface
+	validators []Validator
+	metrics    Metrics
+	stats      *validatorStats
+	cancel     func()
+	wg         sync.WaitGroup
+	results    chan chan *lineBatch
+	pending    chan *lineBatch // a result taken from results before its batch was ready
+	batch      *lineBatch
+	err        error
+}
+
+type validationJob struct {
+	batch  *lineBatch
+	result chan<- *lineBatch
+}
+
+func newValidatingScanner(ctx context.Context, inner iface, validators []Validator, concurrency int, metrics Metrics) *validatingScanner {
+	v := &validatingScanner{
+		inner:      inner,
+		validators: validators,
+		metrics:    metrics,
+		stats:      newValidatorStats(len(validators)),
+		results:    make(chan chan *lineBatch, concurrency*2),
+	}
+	ctx, v.cancel = context.WithCancel(ctx)
+	jobs := make(chan validationJob, concurrency)
+	v.wg.Add(concurrency + 1)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer v.wg.Done()
+			// lines are counted by the inner scanner, so only rejections are counted here
+			var counts lineCounts
+			for job := range jobs {
+				job.batch.filter(func(line []byte) bool {
+					rejectedBy := rejectingValidator(v.validators, line)
+					counts.check(rejectedBy)
+					return rejectedBy == -1
+				})
+				counts.flush(v.metrics, v.stats)
+				job.result <- job.batch
+			}
+		}()
+	}
+	go func() {
+		defer v.wg.Done()
+		defer close(jobs)
+		defer close(v.results)
+		for {
+			batch := inner.takeBatch(ctx)
+			if batch == nil {
+				return
+			}
+			result := make(chan *lineBatch, 1)
+			select {
+			case <-ctx.Done():
+				batch.release()
+				return
+			case v.results <- result:
+			}
+			// result has room for the batch, so workers never block sending it even when nothing receives it
+			jobs <- validationJob{
+				batch:  batch,
+				result: result,
+			}
+		}
+	}()
+	return v
+}
+
+// NextBatch returns the next batch of lines that passed validation. The lines are valid until the next call to NextBatch.
+func (v *validatingScanner) NextBatch(ctx context.Context) [][]byte {
+	if v.batch != nil {
+		v.batch.release()
+		v.batch = nil
+	}
+	v.batch = v.takeBatch(ctx)
+	if v.batch == nil {
+		return nil
+	}
+	return v.batch.lines
+}
+
+// takeBatch returns the next non-empty batch of validated lines in the inner scanner's order. The caller owns it.
+// Canceling ctx ends the scan with ctx's error.
+func (v *validatingScanner) takeBatch(ctx context.Context) *lineBatch {
+	for v.err == nil {
+		if v.pending == nil {
+			select {
+			case result, ok := <-v.results:
+				if !ok {
+					return nil
+				}
+				v.pending = result
+			case <-ctx.Done():
+				v.stop(ctx.Err())
+				return nil
+			}
+		}
+		var batch *lineBatch
+		select {
+		case batch = <-v.pending:
+		case <-ctx.Done():
+			v.stop(ctx.Err())
+			return nil
+		}
+		v.pending = nil
+		if len(batch.lines) > 0 {
+			return batch
+		}
+		batch.release()
+	}
+	return nil
+}
+
+// stop ends the scan with err and stops reading from the inner scanner.
+func (v *validatingScanner) stop(err error) {
+	v.err = err
+	v.cancel()
+}
+
+// ValidatorStats returns counts for each of the validators run by this stage.
+func (v *validatingScanner) ValidatorStats() []ValidatorStats {
+	return v.stats.snapshot()
+}
+
+// BufferedBytes returns the inner scanner's buffered bytes.
+func (v *validatingScanner) BufferedBytes() int64 {
+	return v.inner.BufferedBytes()
+}
+
+func (v *validatingScanner) Err() error {
+	if v.err != nil {
+		return v.err
+	}
+	return v.inner.Err()
+}
+
+func (v *validatingScanner) Close() error {
+	v.cancel()
+	pending := v.pending
+	v.pending = nil
+	go func() {
+		// drain results so that no goroutine is left waiting on a send
+		if pending != nil {
+			(<-pending).release()
+		}
+		for result := range v.results {
+			(<-result).release()
+		}
+	}()
+	v.wg.Wait()
+	err := v.inner.Close()
+	if err == context.Canceled {
+		// the inner scanner saw the cancellation above
+		err = nil
+	}
+	return err
+}
@@ -0,0 +1,145 @@
+package gharchive
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// chanScanner is an iface with batches sent on a channel.
+type chanScanner struct {
+	batches chan *lineBatch
+}
+
+func (c *chanScanner) takeBatch(ctx context.Context) *lineBatch {
+	select {
+	case batch := <-c.batches:
+		return batch
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+func (c *chanScanner) NextBatch(context.Context) [][]byte { return nil }
+func (c *chanScanner) BufferedBytes() int64               { return 0 }
+func (c *chanScanner) ValidatorStats() []ValidatorStats   { return nil }
+func (c *chanScanner) Err() error                         { return nil }
+func (c *chanScanner) Close() error                       { return nil }
+
+func Test_validatingScanner(t *testing.T) {
+	// sendBatch sends a batch with lines to inner
+	sendBatch := func(inner *chanScanner, lines ...string) {
+		batch := newLineBatch()
+		for _, line := range lines {
+			batch.add([]byte(line))
+		}
+		inner.batches <- batch
+	}
+	notB := func(line []byte) bool {
+		return string(line) != "b"
+	}
+
+	t.Run("validates batches in order", func(t *testing.T) {
+		ctx := context.Background()
+		inner := &chanScanner{batches: make(chan *lineBatch, 3)}
+		sendBatch(inner, "a", "b")
+		sendBatch(inner, "b")
+		sendBatch(inner, "c")
+		v := newValidatingScanner(ctx, inner, []Validator{notB}, 2, nil)
+		require.Equal(t, [][]byte{[]byte("a")}, v.NextBatch(ctx))
+		require.Equal(t, [][]byte{[]byte("c")}, v.NextBatch(ctx))
+		require.NoError(t, v.Close())
+		require.Equal(t, []ValidatorStats{{Evaluated: 4, Rejected: 2}}, v.ValidatorStats())
+	})
+
+	t.Run("canceled while waiting for the inner scanner", func(t *testing.T) {
+		inner := &chanScanner{batches: make(chan *lineBatch)}
+		v := newValidatingScanner(context.Background(), inner, []Validator{notB}, 2, nil)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		require.Nil(t, v.NextBatch(ctx))
+		require.Equal(t, context.DeadlineExceeded, v.Err())
+		require.Nil(t, v.NextBatch(context.Background()))
+		require.NoError(t, v.Close())
+	})
+
+	t.Run("canceled while waiting for validators", func(t *testing.T) {
+		inner := &chanScanner{batches: make(chan *lineBatch, 1)}
+		unblock := make(chan struct{})
+		blocking := func(line []byte) bool {
+			<-unblock
+			return true
+		}
+		sendBatch(inner, "a")
+		v := newValidatingScanner(context.Background(), inner, []Validator{blocking}, 2, nil)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		require.Nil(t, v.NextBatch(ctx))
+		require.Equal(t, context.DeadlineExceeded, v.Err())
+		close(unblock)
+		require.NoError(t, v.Close())
+	})
+}
+
+func TestScanner_ValidationConcurrency(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	end := start.Add(4 * time.Hour)
+	src := writeEventHours(t, start, end, 4000)
+	validators := func() []Validator {
+		return []Validator{
+			ValidateNotEmpty(),
+			ValidateJSONFields([]JSONFieldValidator{{
+				Field: "type",
+				Validator: StringValueValidator(func(val string) bool {
+					return val == "PushEvent" || val == "WatchEvent"
+				}),
+			}}),
+		}
+	}
+	want := scanLines(t, start, &Options{
+		Source:        src,
+		EndTime:       end,
+		PreserveOrder: true,
+		Validators:    validators(),
+	})
+	require.NotEmpty(t, want)
+
+	t.Run("preserve order", func(t *testing.T) {
+		got := scanLines(t, start, &Options{
+			Source:                src,
+			EndTime:               end,
+			PreserveOrder:         true,
+			Validators:            validators(),
+			ValidationConcurrency: 4,
+		})
+		require.Equal(t, want, got)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		got := scanLines(t, start, &Options{
+			Source:                src,
+			EndTime:               end,
+			Concurrency:           3,
+			Validators:            validators(),
+			ValidationConcurrency: 4,
+		})
+		require.ElementsMatch(t, want, got)
+	})
+
+	t.Run("close early", func(t *testing.T) {
+		scanner, err := New(ctx, start, &Options{
+			Source:                src,
+			EndTime:               end,
+			PreserveOrder:         true,
+			Validators:            validators(),
+			ValidationConcurrency: 4,
+		})
+		require.NoError(t, err)
+		require.True(t, scanner.Scan(ctx))
+		require.Equal(t, want[0], string(scanner.Bytes()))
+		require.NoError(t, scanner.Close())
+	})
+}
@@ -1,6 +1,8 @@
 package gharchive
 
 import (
+	"strings"
+	"sync/atomic"
 	"time"
 
 	jsoniter "github.com/json-iterator/go"
@@ -13,6 +15,30 @@ var whitespace = [256]bool{
 	'\t': true,
 }
 
+// rejectingValidator returns the index of the first validator that rejects line or -1 when line passes all of them.
+func rejectingValidator(validators []Validator, line []byte) int {
+	for i, validator := range validators {
+		ok := validator(line)
+		if !ok {
+			return i
+		}
+	}
+	return -1
+}
+
+// eventCreatedAt returns the created_at field of an event.
+func eventCreatedAt(line []byte) (time.Time, bool) {
+	val, ok := jsonPathValue(line, []string{"created_at"})
+	if !ok {
+		return time.Time{}, false
+	}
+	createdAt, err := time.Parse(time.RFC3339, string(val))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return createdAt, true
+}
+
 // ValidateNotEmpty validate that line contains at least one non-whitespace character
 func ValidateNotEmpty() Validator {
 	return func(line []byte) bool {
@@ -49,54 +75,135 @@ type JSONFieldValidator struct {
 
 // ValidateJSONFields uses the given validators to validate json field
 func ValidateJSONFields(validators []JSONFieldValidator) Validator {
-	return func(line []byte) bool {
-		iter := jsoniter.ConfigFastest.BorrowIterator(line)
-		defer jsoniter.ConfigFastest.ReturnIterator(iter)
-		done := make([]bool, len(validators))
-		allDone := func() bool {
-			for _, b := range done {
-				if !b {
-					return false
-				}
+	return NewJSONFieldsValidator(validators).Validate
+}
+
+// FieldStats counts the lines checked by a JSONFieldValidator.
+type FieldStats struct {
+	Field     string
+	Evaluated int64 // lines the validator was called with
+	Rejected  int64 // lines the validator returned false for
+	Missing   int64 // lines that were rejected because they don't have the field
+}
+
+// JSONFieldsValidator validates json fields like ValidateJSONFields and counts lines for each field.
+type JSONFieldsValidator struct {
+	validators []JSONFieldValidator
+	evaluated  []int64
+	rejected   []int64
+	missing    []int64
+}
+
+// NewJSONFieldsValidator returns a new JSONFieldsValidator. Use its Validate method as a Validator.
+func NewJSONFieldsValidator(validators []JSONFieldValidator) *JSONFieldsValidator {
+	return &JSONFieldsValidator{
+		validators: validators,
+		evaluated:  make([]int64, len(validators)),
+		rejected:   make([]int64, len(validators)),
+		missing:    make([]int64, len(validators)),
+	}
+}
+
+// FieldStats returns counts for each field validator in the order they were given. It is safe to call while
+// Validate is running.
+func (v *JSONFieldsValidator) FieldStats() []FieldStats {
+	stats := make([]FieldStats, len(v.validators))
+	for i := range stats {
+		stats[i] = FieldStats{
+			Field:     v.validators[i].Field,
+			Evaluated: atomic.LoadInt64(&v.evaluated[i]),
+			Rejected:  atomic.LoadInt64(&v.rejected[i]),
+			Missing:   atomic.LoadInt64(&v.missing[i]),
+		}
+	}
+	return stats
+}
+
+// Validate returns true when every field validator passes. It may be called from multiple goroutines at once.
+func (v *JSONFieldsValidator) Validate(line []byte) bool {
+	validators := v.validators
+	iter := jsoniter.ConfigFastest.BorrowIterator(line)
+	defer jsoniter.ConfigFastest.ReturnIterator(iter)
+	done := make([]bool, len(validators))
+	allDone := func() bool {
+		for _, b := range done {
+			if !b {
+				return false
 			}
-			return true
 		}
-		valid := true
-		iter.ReadObjectCB(func(iter *jsoniter.Iterator, field string) bool {
-			var val interface{}
-			for i := range validators {
-				if allDone() {
+		return true
+	}
+	valid := true
+	iter.ReadObjectCB(func(iter *jsoniter.Iterator, field string) bool {
+		var val interface{}
+		read := false
+		for i := range validators {
+			if done[i] || validators[i].Field != field {
+				continue
+			}
+			if !read {
+				val = iter.ReadAny().GetInterface()
+				read = true
+			}
+			valid = validators[i].Validator(val)
+			atomic.AddInt64(&v.evaluated[i], 1)
+			done[i] = true
+			if !valid {
+				atomic.AddInt64(&v.rejected[i], 1)
+				return false
+			}
+		}
+		if !read {
+			iter.Skip()
+		}
+		return !allDone()
+	})
+	if !valid {
+		return false
+	}
+	if !allDone() {
+		for i, b := range done {
+			if !b {
+				atomic.AddInt64(&v.missing[i], 1)
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// ValidateFieldValues returns true when, for each dot-separated field path in values like repo.name, the line's
+// value for the field is one of the field's values. Values are compared case-insensitively. It accepts the same
+// lines that an Options.IndexFilter with the same values looks for.
+func ValidateFieldValues(values map[string][]string) Validator {
+	// copied so that the caller can change values while the validator runs
+	wantValues := make(map[string][]string, len(values))
+	paths := make(map[string][]string, len(values))
+	for field, want := range values {
+		wantValues[field] = append([]string(nil), want...)
+		paths[field] = strings.Split(field, ".")
+	}
+	return func(line []byte) bool {
+		for field, want := range wantValues {
+			if len(want) == 0 {
+				continue
+			}
+			val, ok := jsonPathValue(line, paths[field])
+			if !ok {
+				return false
+			}
+			found := false
+			for _, w := range want {
+				if strings.EqualFold(string(val), w) {
+					found = true
 					break
 				}
-				valField := validators[i].Field
-				if valField != field {
-					iter.Skip()
-					continue
-				}
-				if done[i] {
-					iter.Skip()
-					continue
-				}
-				if val == nil {
-					val = iter.ReadAny().GetInterface()
-				}
-				validateFunc := validators[i].Validator
-				valid = validateFunc(val)
-				done[i] = true
-				if val == nil {
-					iter.Skip()
-				}
-				if !valid {
-					return false
-				}
 			}
-			ad := allDone()
-			return !ad
-		})
-		if !allDone() {
-			return false
+			if !found {
+				return false
+			}
 		}
-		return valid
+		return true
 	}
 }
 
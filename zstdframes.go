@@ -0,0 +1,378 @@
+package gharchive
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// skipSkippableZstdFrames discards the skippable frames at the start of br. It returns io.EOF when br is at its end.
+func skipSkippableZstdFrames(br *bufio.Reader) error {
+	for {
+		magic, err := br.Peek(4)
+		if err == io.EOF && len(magic) == 0 {
+			return io.EOF
+		}
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		if binary.LittleEndian.Uint32(magic)&0xfffffff0 != 0x184d2a50 {
+			return nil
+		}
+		var header [8]byte
+		_, err = io.ReadFull(br, header[:])
+		if err == nil {
+			_, err = br.Discard(int(binary.LittleEndian.Uint32(header[4:])))
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readZstdFrame appends the next zstd frame in br to buf without decompressing it. It skips skippable frames and
+// returns io.EOF when br is at its end.
+func readZstdFrame(br *bufio.Reader, buf []byte) ([]byte, error) {
+	take := func(n int) error {
+		start := len(buf)
+		for cap(buf) < start+n {
+			buf = append(buf[:cap(buf)], 0)
+		}
+		buf = buf[:start+n]
+		_, err := io.ReadFull(br, buf[start:])
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	err := skipSkippableZstdFrames(br)
+	if err != nil {
+		return buf, err
+	}
+	magic, _ := br.Peek(4) //nolint:errcheck // skipSkippableZstdFrames already peeked 4 bytes
+	if !bytes.Equal(magic, zstdMagic) {
+		return buf, zstd.ErrMagicMismatch
+	}
+	err = take(5)
+	if err != nil {
+		return buf, err
+	}
+	descriptor := buf[len(buf)-1]
+	singleSegment := descriptor&0x20 != 0
+	headerSize := [4]int{0, 1, 2, 4}[descriptor&3] + [4]int{0, 2, 4, 8}[descriptor>>6]
+	if !singleSegment {
+		headerSize++ // window descriptor
+	} else if descriptor>>6 == 0 {
+		headerSize++ // a one byte content size
+	}
+	err = take(headerSize)
+	if err != nil {
+		return buf, err
+	}
+	for {
+		err = take(3)
+		if err != nil {
+			return buf, err
+		}
+		b := buf[len(buf)-3:]
+		header := uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+		size := int(header >> 3)
+		switch (header >> 1) & 3 {
+		case 1:
+			size = 1 // rle blocks have one byte repeated size times
+		case 3:
+			return buf, zstd.ErrReservedBlockType
+		}
+		err = take(size)
+		if err != nil {
+			return buf, err
+		}
+		if header&1 != 0 {
+			break
+		}
+	}
+	if descriptor&4 != 0 {
+		err = take(4) // content checksum
+	}
+	return buf, err
+}
+
+// zstdFrameError is returned by zstdFrameDecoder when a frame's content can't be decompressed. The frames around it
+// are unaffected.
+type zstdFrameError struct {
+	start int64 // offset of the frame in the object
+	end   int64 // offset of the next frame
+	err   error
+}
+
+func (e *zstdFrameError) Error() string {
+	return fmt.Sprintf("zstd frame at offset %d: %v", e.start, e.err)
+}
+
+func (e *zstdFrameError) Unwrap() error {
+	return e.err
+}
+
+// maxZstdFrameSize is the largest frame content size that zstdFrameDecoder decompresses. Frames are decompressed
+// whole, so bigger frames and the rest of the object after them are streamed instead.
+const maxZstdFrameSize = 64 << 20
+
+// peekZstdFrameSize returns the content size in the header of the zstd frame at the start of br. ok is false when there
+// isn't a frame header with a content size.
+func peekZstdFrameSize(br *bufio.Reader) (size int64, ok bool) {
+	header, _ := br.Peek(18) //nolint:errcheck // a short header is checked below
+	if len(header) < 5 || !bytes.Equal(header[:4], zstdMagic) {
+		return 0, false
+	}
+	descriptor := header[4]
+	fcsFlag := descriptor >> 6
+	singleSegment := descriptor&0x20 != 0
+	if fcsFlag == 0 && !singleSegment {
+		return 0, false
+	}
+	pos := 5 + [4]int{0, 1, 2, 4}[descriptor&3]
+	if !singleSegment {
+		pos++
+	}
+	fcsSize := [4]int{1, 2, 4, 8}[fcsFlag]
+	if len(header) < pos+fcsSize {
+		return 0, false
+	}
+	var buf [8]byte
+	copy(buf[:], header[pos:pos+fcsSize])
+	size = int64(binary.LittleEndian.Uint64(buf[:]))
+	if fcsSize == 2 {
+		size += 256
+	}
+	return size, true
+}
+
+// streamZstdFrame returns true when the zstd frame at the start of br doesn't say how big its content is or says it
+// is bigger than maxZstdFrameSize. It returns false when br doesn't start with a frame so that readZstdFrame reports
+// what is there.
+func streamZstdFrame(br *bufio.Reader) bool {
+	magic, _ := br.Peek(4) //nolint:errcheck // a short read isn't a frame
+	if !bytes.Equal(magic, zstdMagic) {
+		return false
+	}
+	size, ok := peekZstdFrameSize(br)
+	return !ok || size > maxZstdFrameSize
+}
+
+// zstdFrame is a frame that one of zstdFrameDecoder's workers is decompressing.
+type zstdFrame struct {
+	result chan struct{} // closed when data and err are set
+	data   []byte
+	err    error
+	stream bool // the frame is too big to decompress whole, so it and the rest of br are streamed
+}
+
+// zstdFrameDecoder decompresses the zstd frames in br whole. With concurrency > 1, it reads frames on one goroutine
+// and decompresses up to concurrency of them at a time on others. Read returns their content in order. A frame that
+// can't be decompressed is returned as a *zstdFrameError and reading can continue after it. Other errors end reading,
+// leaving br where the bad frame starts. Once it reaches a frame that is too big to decompress whole, it streams the
+// rest of br.
+type zstdFrameDecoder struct {
+	br      *bufio.Reader
+	offset  func() int64
+	dec     *zstd.Decoder
+	stream  *zstd.Decoder   // streams the rest of br once a frame is too big to decompress whole
+	frames  chan *zstdFrame // nil when frames are decompressed by Read
+	done    chan struct{}
+	exited  chan struct{}
+	workers sync.WaitGroup
+	cur     *zstdFrame
+	err     error
+	buf     []byte // compressed frame for Read to decompress
+}
+
+// newZstdFrameDecoder starts decompressing the frames in br. offset returns the offset in the object of the next byte
+// in br.
+func newZstdFrameDecoder(br *bufio.Reader, offset func() int64, concurrency int) (*zstdFrameDecoder, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(concurrency), zstd.WithDecoderMaxMemory(maxZstdFrameSize))
+	if err != nil {
+		return nil, err
+	}
+	d := &zstdFrameDecoder{
+		br:     br,
+		offset: offset,
+		dec:    dec,
+		done:   make(chan struct{}),
+	}
+	if concurrency > 1 {
+		// frames holds the frames being decompressed and those waiting to be read
+		d.frames = make(chan *zstdFrame, concurrency)
+		d.exited = make(chan struct{})
+		go d.run()
+	}
+	return d, nil
+}
+
+// decodeFrame decompresses compressed, the frame from start to end, into dst.
+func (d *zstdFrameDecoder) decodeFrame(compressed, dst []byte, start, end int64) ([]byte, error) {
+	data, err := d.dec.DecodeAll(compressed, dst[:0])
+	if err != nil {
+		return nil, &zstdFrameError{
+			start: start,
+			end:   end,
+			err:   err,
+		}
+	}
+	return data, nil
+}
+
+// nextFrame reads the next frame from br and decompresses it on the calling goroutine.
+func (d *zstdFrameDecoder) nextFrame() *zstdFrame {
+	frame := new(zstdFrame)
+	if d.cur != nil {
+		frame.data = d.cur.data[:0]
+	}
+	start := d.offset()
+	err := skipSkippableZstdFrames(d.br)
+	if err == nil && streamZstdFrame(d.br) {
+		frame.stream = true
+		return frame
+	}
+	if err == nil {
+		d.buf, err = readZstdFrame(d.br, d.buf[:0])
+	}
+	if err != nil {
+		frame.err = err
+		return frame
+	}
+	frame.data, frame.err = d.decodeFrame(d.buf, frame.data, start, d.offset())
+	return frame
+}
+
+func (d *zstdFrameDecoder) run() {
+	defer close(d.exited)
+	defer close(d.frames)
+	for {
+		frame := &zstdFrame{
+			result: make(chan struct{}),
+		}
+		start := d.offset()
+		err := skipSkippableZstdFrames(d.br)
+		if err == nil && streamZstdFrame(d.br) {
+			// Read streams the rest of br once it gets to this frame
+			frame.stream = true
+			close(frame.result)
+			select {
+			case d.frames <- frame:
+			case <-d.done:
+			}
+			return
+		}
+		var compressed []byte
+		if err == nil {
+			compressed, err = readZstdFrame(d.br, nil)
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			frame.err = err
+			close(frame.result)
+		} else {
+			end := d.offset()
+			d.workers.Add(1)
+			go func() {
+				defer d.workers.Done()
+				defer close(frame.result)
+				frame.data, frame.err = d.decodeFrame(compressed, nil, start, end)
+			}()
+		}
+		select {
+		case d.frames <- frame:
+		case <-d.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (d *zstdFrameDecoder) Read(p []byte) (int, error) {
+	for d.cur == nil || len(d.cur.data) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.stream != nil {
+			return d.readStream(p)
+		}
+		if d.cur != nil && d.cur.stream {
+			var err error
+			// no frames are read from br after this one, so Read can have it
+			d.stream, err = zstd.NewReader(d.br, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				d.err = err
+				return 0, err
+			}
+			continue
+		}
+		if d.cur != nil && d.cur.err != nil {
+			err := d.cur.err
+			d.cur.err = nil
+			if _, ok := err.(*zstdFrameError); !ok {
+				d.err = err
+			}
+			return 0, err
+		}
+		if d.frames == nil {
+			d.cur = d.nextFrame()
+			continue
+		}
+		var ok bool
+		d.cur, ok = <-d.frames
+		if !ok {
+			d.err = io.EOF
+			return 0, io.EOF
+		}
+		<-d.cur.result
+	}
+	n := copy(p, d.cur.data)
+	d.cur.data = d.cur.data[n:]
+	return n, nil
+}
+
+// readStream reads from the decoder that streams the rest of br.
+func (d *zstdFrameDecoder) readStream(p []byte) (int, error) {
+	n, err := d.stream.Read(p)
+	if err != nil {
+		d.err = err
+	}
+	return n, err
+}
+
+// Close stops reading frames and releases the decoders once the workers are done.
+func (d *zstdFrameDecoder) Close() {
+	select {
+	case <-d.done:
+		return
+	default:
+	}
+	close(d.done)
+	if d.exited != nil {
+		<-d.exited
+	}
+	d.workers.Wait()
+	d.dec.Close()
+	if d.stream != nil {
+		d.stream.Close()
+	}
+}
@@ -0,0 +1,163 @@
+package gharchive
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+// decodeZstdFrames reads everything from a zstdFrameDecoder over data.
+func decodeZstdFrames(t *testing.T, data []byte, concurrency int) []byte {
+	t.Helper()
+	downloaded := &countingReader{r: bytes.NewReader(data)}
+	br := bufio.NewReader(downloaded)
+	offset := func() int64 {
+		return downloaded.count - int64(br.Buffered())
+	}
+	dec, err := newZstdFrameDecoder(br, offset, concurrency)
+	require.NoError(t, err)
+	defer dec.Close()
+	got, err := io.ReadAll(dec)
+	require.NoError(t, err)
+	return got
+}
+
+func Test_zstdFrameDecoder(t *testing.T) {
+	enc, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, enc.Close()) })
+	small := []byte("small frame\n")
+	smallFrame := enc.EncodeAll(small, nil)
+	big := bytes.Repeat([]byte("big frame\n"), maxZstdFrameSize/10+1)
+	bigFrame := enc.EncodeAll(big, nil)
+	unsized := []byte("frame without a content size\n")
+	var buf bytes.Buffer
+	streamEnc, err := zstd.NewWriter(&buf)
+	require.NoError(t, err)
+	_, err = streamEnc.Write(unsized)
+	require.NoError(t, err)
+	// flushing writes the frame header before the content size is known
+	require.NoError(t, streamEnc.Flush())
+	require.NoError(t, streamEnc.Close())
+	unsizedFrame := buf.Bytes()
+	skippableFrame := binary.LittleEndian.AppendUint32(nil, 0x184d2a50)
+	skippableFrame = binary.LittleEndian.AppendUint32(skippableFrame, 3)
+	skippableFrame = append(skippableFrame, "abc"...)
+
+	for _, td := range []struct {
+		name   string
+		frames [][]byte
+		want   [][]byte
+	}{
+		{
+			name:   "small frames",
+			frames: [][]byte{smallFrame, smallFrame},
+			want:   [][]byte{small, small},
+		},
+		{
+			name:   "too big to decompress whole",
+			frames: [][]byte{smallFrame, bigFrame, smallFrame},
+			want:   [][]byte{small, big, small},
+		},
+		{
+			name:   "skippable frame before one that is too big",
+			frames: [][]byte{smallFrame, skippableFrame, bigFrame, smallFrame},
+			want:   [][]byte{small, big, small},
+		},
+		{
+			name:   "without a content size",
+			frames: [][]byte{smallFrame, unsizedFrame, smallFrame},
+			want:   [][]byte{small, unsized, small},
+		},
+	} {
+		for _, concurrency := range []int{1, 4} {
+			t.Run(fmt.Sprintf("%s with concurrency %d", td.name, concurrency), func(t *testing.T) {
+				got := decodeZstdFrames(t, bytes.Join(td.frames, nil), concurrency)
+				// require.Equal would print megabytes on failure
+				require.True(t, bytes.Equal(bytes.Join(td.want, nil), got))
+			})
+		}
+	}
+}
+
+func TestScanner_zstd(t *testing.T) {
+	start := time.Date(2020, 10, 10, 8, 0, 0, 0, time.UTC)
+	lines := testEventLines(start, 300)
+	enc, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	frames := make([][]byte, 3)
+	for i := range frames {
+		frames[i] = enc.EncodeAll(bytes.Join(lines[i*100:(i+1)*100], nil), nil)
+	}
+	require.NoError(t, enc.Close())
+	zstdName, ok := ZstdName(ObjectName(start))
+	require.True(t, ok)
+	want := lineStrings(lines)
+	zstdSource := func(t *testing.T, data []byte) *DirSource {
+		t.Helper()
+		src := NewDirSource(t.TempDir())
+		writeObject(t, src, zstdName, data)
+		return src
+	}
+
+	t.Run("read in place of a missing gzip object", func(t *testing.T) {
+		got := scanLines(t, start, &Options{
+			Source:     zstdSource(t, bytes.Join(frames, nil)),
+			Validators: []Validator{ValidateNotEmpty()},
+		})
+		require.Equal(t, want, got)
+	})
+
+	t.Run("frames without content sizes", func(t *testing.T) {
+		var buf bytes.Buffer
+		streamEnc, err := zstd.NewWriter(&buf)
+		require.NoError(t, err)
+		for _, line := range lines {
+			_, err = streamEnc.Write(line)
+			require.NoError(t, err)
+			// flushing writes the frame header before the content size is known
+			require.NoError(t, streamEnc.Flush())
+		}
+		require.NoError(t, streamEnc.Close())
+		src := zstdSource(t, buf.Bytes())
+		for _, decodeConcurrency := range []int{0, 4} {
+			got := scanLines(t, start, &Options{
+				Source:            src,
+				Validators:        []Validator{ValidateNotEmpty()},
+				DecodeConcurrency: decodeConcurrency,
+			})
+			require.Equal(t, want, got)
+		}
+	})
+
+	corruptContent := append([]byte{}, frames[1]...)
+	corruptContent[len(corruptContent)/2] ^= 0xff
+	for _, decodeConcurrency := range []int{0, 4} {
+		for name, badFrame := range map[string][]byte{
+			"bad magic":       append([]byte{0}, frames[1][1:]...),
+			"corrupt content": corruptContent,
+		} {
+			t.Run(fmt.Sprintf("resync after %s with DecodeConcurrency %d", name, decodeConcurrency), func(t *testing.T) {
+				var corruptions []*CorruptionError
+				got := scanLines(t, start, &Options{
+					Source:            zstdSource(t, bytes.Join([][]byte{frames[0], badFrame, frames[2]}, nil)),
+					Validators:        []Validator{ValidateNotEmpty()},
+					OnCorruption:      CorruptionResync,
+					ReportCorruption:  func(e *CorruptionError) { corruptions = append(corruptions, e) },
+					DecodeConcurrency: decodeConcurrency,
+				})
+				require.Equal(t, append(append([]string{}, want[:100]...), want[200:]...), got)
+				require.Len(t, corruptions, 1)
+				require.Equal(t, ObjectName(start), corruptions[0].Object)
+				require.Equal(t, int64(len(frames[0])+len(frames[1])), corruptions[0].Resumed)
+			})
+		}
+	}
+}